@@ -0,0 +1,403 @@
+// ADaM subject-level analysis data set (ADSL) for the fictitious study.
+// One record per screened subject, derived from DM and SC.
+// Metadata :
+// - STUDYID  Char 6  Study Identifier
+// - USUBJID  Char 18 Unique Subject Identifier
+// - SUBJID   Char 6  Subject Identifier for the Study
+// - SITEID   Char 4  Study Site Identifier
+// - COUNTRY  Char 3  Country
+// - AGE      Num     Age
+// - AGEU     Char 5  Age Units
+// - AGEGR1   Char 5  Pooled Age Group 1 (<40, 40-64, >=65)
+// - AGEGR1N  Num     Pooled Age Group 1 (N)
+// - SEX      Char 1  Sex
+// - RACE     Char 5  Race
+// - ARM      Char 7  Description of Planned Arm
+// - TRT01P   Char 7  Planned Treatment for Period 01
+// - TRT01PN  Num     Planned Treatment for Period 01 (N)
+// - TRT01A   Char 7  Actual Treatment for Period 01
+// - TRT01AN  Num     Actual Treatment for Period 01 (N)
+// - RANDFL   Char 1  Randomized Population Flag
+// - ITTFL    Char 1  Intent-To-Treat Population Flag (all randomized subjects)
+// - SAFFL    Char 1  Safety Population Flag (randomized subjects who took study medication)
+// - PPROTFL  Char 1  Per-Protocol Population Flag (safety subjects attending at least
+//                    half of the dosing visits with non-missing age and sex)
+// - COMPLFL  Char 1  Completers Population Flag
+// - TRTSDT   Num     Date of First Exposure to Treatment
+// - TRTEDT   Num     Date of Last Exposure to Treatment
+// - TRTDURD  Num     Total Treatment Duration (Days)
+// - EOSSTT   Char 12 End of Study Status (COMPLETED, DISCONTINUED)
+// - EOSDT    Num     End of Study Date
+// - DCSREAS  Char 22 Reason for Discontinuation from Study
+// - LSTVISN  Num     Last Visit Number attended
+//
+// Flags are Y or N. Dates are ISO8601 in the CSV and SAS dates in the XPT file.
+package ADaM
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phil0lucas/GoForCP/CPUtils"
+	"github.com/phil0lucas/GoForCP/DM"
+	"github.com/phil0lucas/GoForCP/SC"
+	"github.com/phil0lucas/GoForCP/XPT"
+)
+
+// The ADSL record. Variables that may be missing are pointers.
+type Adslrec struct {
+	Studyid string
+	Usubjid string
+	Subjid  string
+	Siteid  string
+	Country string
+	Age     *int
+	Ageu    string
+	Agegr1  *string
+	Agegr1n *int
+	Sex     *string
+	Race    *string
+	Arm     *string
+	Trt01p  *string
+	Trt01pn *int
+	Trt01a  *string
+	Trt01an *int
+	Randfl  string
+	Ittfl   string
+	Saffl   string
+	Pprotfl string
+	Complfl string
+	Trtsdt  *time.Time
+	Trtedt  *time.Time
+	Trtdurd *int
+	Eosstt  string
+	Eosdt   *time.Time
+	Dcsreas *string
+	Lstvisn int
+}
+
+// Variable definitions for the transport file, in the order of the CSV
+var adslVars = []XPT.Var{
+	{Name: "STUDYID", Label: "Study Identifier", Length: 6},
+	{Name: "USUBJID", Label: "Unique Subject Identifier", Length: 18},
+	{Name: "SUBJID", Label: "Subject Identifier for the Study", Length: 6},
+	{Name: "SITEID", Label: "Study Site Identifier", Length: 4},
+	{Name: "COUNTRY", Label: "Country", Length: 3},
+	{Name: "AGE", Label: "Age", Numeric: true},
+	{Name: "AGEU", Label: "Age Units", Length: 5},
+	{Name: "AGEGR1", Label: "Pooled Age Group 1", Length: 5},
+	{Name: "AGEGR1N", Label: "Pooled Age Group 1 (N)", Numeric: true},
+	{Name: "SEX", Label: "Sex", Length: 1},
+	{Name: "RACE", Label: "Race", Length: 5},
+	{Name: "ARM", Label: "Description of Planned Arm", Length: 7},
+	{Name: "TRT01P", Label: "Planned Treatment for Period 01", Length: 7},
+	{Name: "TRT01PN", Label: "Planned Treatment for Period 01 (N)", Numeric: true},
+	{Name: "TRT01A", Label: "Actual Treatment for Period 01", Length: 7},
+	{Name: "TRT01AN", Label: "Actual Treatment for Period 01 (N)", Numeric: true},
+	{Name: "RANDFL", Label: "Randomized Population Flag", Length: 1},
+	{Name: "ITTFL", Label: "Intent-To-Treat Population Flag", Length: 1},
+	{Name: "SAFFL", Label: "Safety Population Flag", Length: 1},
+	{Name: "PPROTFL", Label: "Per-Protocol Population Flag", Length: 1},
+	{Name: "COMPLFL", Label: "Completers Population Flag", Length: 1},
+	{Name: "TRTSDT", Label: "Date of First Exposure to Treatment", Numeric: true, Format: "DATE9."},
+	{Name: "TRTEDT", Label: "Date of Last Exposure to Treatment", Numeric: true, Format: "DATE9."},
+	{Name: "TRTDURD", Label: "Total Treatment Duration (Days)", Numeric: true},
+	{Name: "EOSSTT", Label: "End of Study Status", Length: 12},
+	{Name: "EOSDT", Label: "End of Study Date", Numeric: true, Format: "DATE9."},
+	{Name: "DCSREAS", Label: "Reason for Discontinuation from Study", Length: 22},
+	{Name: "LSTVISN", Label: "Last Visit Number", Numeric: true},
+}
+
+// Numeric codes of the treatments
+var trtn = map[string]int{"Placebo": 0, "Active": 1}
+
+// Number of dosing visits a per-protocol subject must attend
+const ppVisits = 7
+
+// Convert a boolean into a Y/N flag
+func yn(b bool) string {
+	if b {
+		return "Y"
+	}
+	return "N"
+}
+
+// Derive the age group and its code from the age
+func ageGroup(age *int) (*string, *int) {
+	if age == nil {
+		return nil, nil
+	}
+	var g string
+	var n int
+	switch {
+	case *age < 40:
+		g, n = "<40", 1
+	case *age < 65:
+		g, n = "40-64", 2
+	default:
+		g, n = ">=65", 3
+	}
+	return &g, &n
+}
+
+// Derive ADSL from DM and SC, matching the subjects on USUBJID.
+// Subjects in DM but not in SC are treated as screening failures.
+func DeriveADSL(dm []*DM.Dmrec, sc []*SC.Subject) []*Adslrec {
+	subj := make(map[string]*SC.Subject)
+	for _, s := range sc {
+		subj[s.Usubjid] = s
+	}
+
+	var adsl []*Adslrec
+	for _, d := range dm {
+		s := subj[d.Usubjid]
+		a := &Adslrec{
+			Studyid: d.Studyid,
+			Usubjid: d.Usubjid,
+			Subjid:  d.Subjid,
+			Siteid:  d.Siteid,
+			Country: d.Country,
+			Age:     d.Age,
+			Ageu:    d.Ageu,
+			Sex:     d.Sex,
+			Race:    d.Race,
+			Arm:     d.Arm,
+		}
+		a.Agegr1, a.Agegr1n = ageGroup(d.Age)
+
+		// Randomized subjects have a planned treatment
+		randomized := d.Arm != nil
+		if randomized {
+			n := trtn[*d.Arm]
+			a.Trt01p, a.Trt01pn = d.Arm, &n
+		}
+
+		// Exposure comes from the reference dates.
+		// Subjects receive the treatment they were randomized to.
+		a.Trtsdt, a.Trtedt = d.Rfstdtc, d.Rfendtc
+		dosed := randomized && a.Trtsdt != nil
+		if dosed {
+			a.Trt01a, a.Trt01an = a.Trt01p, a.Trt01pn
+		}
+		if a.Trtsdt != nil && a.Trtedt != nil {
+			dur := int(a.Trtedt.Sub(*a.Trtsdt).Hours()/24) + 1
+			a.Trtdurd = &dur
+		}
+
+		// Disposition
+		rectype := 0
+		if s != nil {
+			rectype = s.Rectype
+			a.Lstvisn = s.Endv
+		}
+		completed := rectype == 2
+		if completed {
+			a.Eosstt = "COMPLETED"
+		} else {
+			a.Eosstt = "DISCONTINUED"
+			var reason string
+			if rectype == 0 {
+				reason = "SCREEN FAILURE"
+			} else {
+				reason = "WITHDRAWAL BY SUBJECT"
+			}
+			a.Dcsreas = &reason
+		}
+		if a.Trtedt != nil {
+			a.Eosdt = a.Trtedt
+		} else {
+			eos := d.Dmdtc
+			a.Eosdt = &eos
+		}
+
+		// Population flags
+		a.Randfl = yn(randomized)
+		a.Ittfl = yn(randomized)
+		a.Saffl = yn(dosed)
+		a.Complfl = yn(dosed && completed)
+		a.Pprotfl = yn(dosed && a.Lstvisn >= ppVisits && d.Age != nil && d.Sex != nil)
+
+		adsl = append(adsl, a)
+	}
+	return adsl
+}
+
+// The values of a record in character form, in the order of adslVars
+func (a *Adslrec) fields() []string {
+	return []string{
+		a.Studyid,
+		a.Usubjid,
+		a.Subjid,
+		a.Siteid,
+		a.Country,
+		CPUtils.IntP2Str(a.Age),
+		a.Ageu,
+		CPUtils.StrP2Str(a.Agegr1),
+		CPUtils.IntP2Str(a.Agegr1n),
+		CPUtils.StrP2Str(a.Sex),
+		CPUtils.StrP2Str(a.Race),
+		CPUtils.StrP2Str(a.Arm),
+		CPUtils.StrP2Str(a.Trt01p),
+		CPUtils.IntP2Str(a.Trt01pn),
+		CPUtils.StrP2Str(a.Trt01a),
+		CPUtils.IntP2Str(a.Trt01an),
+		a.Randfl,
+		a.Ittfl,
+		a.Saffl,
+		a.Pprotfl,
+		a.Complfl,
+		CPUtils.DateP2Str(a.Trtsdt),
+		CPUtils.DateP2Str(a.Trtedt),
+		CPUtils.IntP2Str(a.Trtdurd),
+		a.Eosstt,
+		CPUtils.DateP2Str(a.Eosdt),
+		CPUtils.StrP2Str(a.Dcsreas),
+		strconv.Itoa(a.Lstvisn),
+	}
+}
+
+// Derive ADSL from the DM and SC files and write it to CSV and,
+// if a file name is given, to a SAS transport file.
+func WriteADSL(dmfile, scfile, outfile, xptfile *string) {
+	adsl := DeriveADSL(DM.ReadDM(dmfile), SC.ReadSC(scfile))
+
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fo.Close()
+
+	w := bufio.NewWriter(fo)
+	var rows [][]string
+	for _, a := range adsl {
+		f := a.fields()
+		rows = append(rows, f)
+		if _, err := w.WriteString(strings.Join(f, ",") + "\n"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	if xptfile != nil && *xptfile != "" {
+		if err := XPT.Write(xptfile, "ADSL", "Subject-Level Analysis Dataset", adslVars, rows); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Read the ADSL CSV into the same slice of structs
+func ReadADSL(infile *string) []*Adslrec {
+	file, err := os.Open(*infile)
+	if err != nil {
+		panic(fmt.Sprintf("error opening %s: %v", *infile, err))
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	var adsl []*Adslrec
+	for i := 0; scanner.Scan(); i++ {
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "error reading from file:", err)
+			os.Exit(3)
+		}
+		s := strings.Split(scanner.Text(), ",")
+		lstvisn, _ := strconv.Atoi(s[27])
+		adsl = append(adsl, &Adslrec{
+			Studyid: s[0],
+			Usubjid: s[1],
+			Subjid:  s[2],
+			Siteid:  s[3],
+			Country: s[4],
+			Age:     CPUtils.Str2IntP(s[5]),
+			Ageu:    s[6],
+			Agegr1:  CPUtils.Str2StrP(s[7]),
+			Agegr1n: CPUtils.Str2IntP(s[8]),
+			Sex:     CPUtils.Str2StrP(s[9]),
+			Race:    CPUtils.Str2StrP(s[10]),
+			Arm:     CPUtils.Str2StrP(s[11]),
+			Trt01p:  CPUtils.Str2StrP(s[12]),
+			Trt01pn: CPUtils.Str2IntP(s[13]),
+			Trt01a:  CPUtils.Str2StrP(s[14]),
+			Trt01an: CPUtils.Str2IntP(s[15]),
+			Randfl:  s[16],
+			Ittfl:   s[17],
+			Saffl:   s[18],
+			Pprotfl: s[19],
+			Complfl: s[20],
+			Trtsdt:  CPUtils.Str2DateP(s[21]),
+			Trtedt:  CPUtils.Str2DateP(s[22]),
+			Trtdurd: CPUtils.Str2IntP(s[23]),
+			Eosstt:  s[24],
+			Eosdt:   CPUtils.Str2DateP(s[25]),
+			Dcsreas: CPUtils.Str2StrP(s[26]),
+			Lstvisn: lstvisn,
+		})
+	}
+	return adsl
+}
+
+// The value of a population flag (ITTFL, SAFFL, PPROTFL, COMPLFL or RANDFL)
+func (a *Adslrec) Flag(name string) string {
+	switch strings.ToUpper(name) {
+	case "RANDFL":
+		return a.Randfl
+	case "ITTFL":
+		return a.Ittfl
+	case "SAFFL":
+		return a.Saffl
+	case "PPROTFL":
+		return a.Pprotfl
+	case "COMPLFL":
+		return a.Complfl
+	}
+	return ""
+}
+
+// The value of a variable of a record by name, in character form
+func getVar(vars []XPT.Var, fields []string, name string) string {
+	name = strings.ToUpper(name)
+	for i, v := range vars {
+		if v.Name == name {
+			return fields[i]
+		}
+	}
+	return ""
+}
+
+// The value of a variable by name, as written to the CSV
+func (a *Adslrec) Get(name string) string {
+	return getVar(adslVars, a.fields(), name)
+}
+
+// The set of subjects flagged Y for a population
+func Flagged(adsl []*Adslrec, flag string) map[string]bool {
+	m := make(map[string]bool)
+	for _, a := range adsl {
+		if a.Flag(flag) == "Y" {
+			m[a.Usubjid] = true
+		}
+	}
+	return m
+}
+
+// A map of Usubjid to treatment for the subjects in a population.
+// The planned treatment (TRT01P) is used unless actual is true (TRT01A).
+func TrtMap(adsl []*Adslrec, flag string, actual bool) map[string]string {
+	m := make(map[string]string)
+	for _, a := range adsl {
+		trt := a.Trt01p
+		if actual {
+			trt = a.Trt01a
+		}
+		if a.Flag(flag) == "Y" && trt != nil {
+			m[a.Usubjid] = *trt
+		}
+	}
+	return m
+}
@@ -0,0 +1,261 @@
+// ADaM time-to-event analysis data set (ADTTE) for the fictitious study.
+// One record per subject and parameter, derived from ADSL for the randomized
+// subjects who started treatment.
+// Metadata :
+// - STUDYID  Char 6  Study Identifier
+// - USUBJID  Char 18 Unique Subject Identifier
+// - SUBJID   Char 6  Subject Identifier for the Study
+// - SITEID   Char 4  Study Site Identifier
+// - TRTP     Char 7  Planned Treatment (TRT01P)
+// - TRTPN    Num     Planned Treatment (N)
+// - TRTA     Char 7  Actual Treatment (TRT01A)
+// - TRTAN    Num     Actual Treatment (N)
+// - SAFFL    Char 1  Safety Population Flag
+// - ITTFL    Char 1  Intent-To-Treat Population Flag
+// - PARAMCD  Char 8  Parameter Code
+// - PARAM    Char 40 Parameter
+// - PARAMN   Num     Parameter (N)
+// - STARTDT  Num     Time to Event Origin Date (TRTSDT)
+// - ADT      Num     Analysis Date, of the event or censoring
+// - AVAL     Num     Analysis Value, days from STARTDT to ADT inclusive
+// - CNSR     Num     Censor (0=event, 1=censored)
+// - EVNTDESC Char 30 Event or Censoring Description
+// - CNSDTDSC Char 30 Censor Date Description
+//
+// Parameters:
+// - TTDISC  Time to Study Discontinuation. The event is withdrawal from the
+//           study on EOSDT; completers are censored at their end of study.
+// Time to first adverse event will be added as a parameter once an AE
+// domain is available.
+package ADaM
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phil0lucas/GoForCP/CPUtils"
+	"github.com/phil0lucas/GoForCP/XPT"
+)
+
+// The ADTTE record. Variables that may be missing are pointers.
+type Adtterec struct {
+	Studyid  string
+	Usubjid  string
+	Subjid   string
+	Siteid   string
+	Trtp     *string
+	Trtpn    *int
+	Trta     *string
+	Trtan    *int
+	Saffl    string
+	Ittfl    string
+	Paramcd  string
+	Param    string
+	Paramn   int
+	Startdt  time.Time
+	Adt      time.Time
+	Aval     int
+	Cnsr     int
+	Evntdesc string
+	Cnsdtdsc *string
+}
+
+// Variable definitions for the transport file, in the order of the CSV
+var adtteVars = []XPT.Var{
+	{Name: "STUDYID", Label: "Study Identifier", Length: 6},
+	{Name: "USUBJID", Label: "Unique Subject Identifier", Length: 18},
+	{Name: "SUBJID", Label: "Subject Identifier for the Study", Length: 6},
+	{Name: "SITEID", Label: "Study Site Identifier", Length: 4},
+	{Name: "TRTP", Label: "Planned Treatment", Length: 7},
+	{Name: "TRTPN", Label: "Planned Treatment (N)", Numeric: true},
+	{Name: "TRTA", Label: "Actual Treatment", Length: 7},
+	{Name: "TRTAN", Label: "Actual Treatment (N)", Numeric: true},
+	{Name: "SAFFL", Label: "Safety Population Flag", Length: 1},
+	{Name: "ITTFL", Label: "Intent-To-Treat Population Flag", Length: 1},
+	{Name: "PARAMCD", Label: "Parameter Code", Length: 8},
+	{Name: "PARAM", Label: "Parameter", Length: 40},
+	{Name: "PARAMN", Label: "Parameter (N)", Numeric: true},
+	{Name: "STARTDT", Label: "Time to Event Origin Date", Numeric: true, Format: "DATE9."},
+	{Name: "ADT", Label: "Analysis Date", Numeric: true, Format: "DATE9."},
+	{Name: "AVAL", Label: "Analysis Value", Numeric: true},
+	{Name: "CNSR", Label: "Censor", Numeric: true},
+	{Name: "EVNTDESC", Label: "Event or Censoring Description", Length: 30},
+	{Name: "CNSDTDSC", Label: "Censor Date Description", Length: 30},
+}
+
+// A time-to-event parameter. The derivation returns the event or censoring
+// date, the censor value and the descriptions, or false if the subject
+// has no record for the parameter.
+type tteParam struct {
+	paramcd string
+	param   string
+	derive  func(a *Adslrec) (time.Time, int, string, *string, bool)
+}
+
+// The parameters derived, in order
+var tteParams = []tteParam{
+	{"TTDISC", "Time to Study Discontinuation (Days)", ttDisc},
+}
+
+// Time to study discontinuation: withdrawers have an event at the end of
+// study, completers are censored at the end of study.
+func ttDisc(a *Adslrec) (time.Time, int, string, *string, bool) {
+	if a.Eosdt == nil {
+		return time.Time{}, 0, "", nil, false
+	}
+	if a.Eosstt == "DISCONTINUED" {
+		return *a.Eosdt, 0, CPUtils.StrP2Str(a.Dcsreas), nil, true
+	}
+	cns := "END OF STUDY"
+	return *a.Eosdt, 1, "COMPLETED STUDY", &cns, true
+}
+
+// Derive ADTTE from ADSL. Subjects are included if randomized and
+// treated, i.e. they have a treatment start date.
+func DeriveADTTE(adsl []*Adslrec) []*Adtterec {
+	var adtte []*Adtterec
+	for _, a := range adsl {
+		if a.Randfl != "Y" || a.Trtsdt == nil {
+			continue
+		}
+		for i, p := range tteParams {
+			adt, cnsr, evnt, cnsdesc, ok := p.derive(a)
+			if !ok {
+				continue
+			}
+			adtte = append(adtte, &Adtterec{
+				Studyid:  a.Studyid,
+				Usubjid:  a.Usubjid,
+				Subjid:   a.Subjid,
+				Siteid:   a.Siteid,
+				Trtp:     a.Trt01p,
+				Trtpn:    a.Trt01pn,
+				Trta:     a.Trt01a,
+				Trtan:    a.Trt01an,
+				Saffl:    a.Saffl,
+				Ittfl:    a.Ittfl,
+				Paramcd:  p.paramcd,
+				Param:    p.param,
+				Paramn:   i + 1,
+				Startdt:  *a.Trtsdt,
+				Adt:      adt,
+				Aval:     int(adt.Sub(*a.Trtsdt).Hours()/24) + 1,
+				Cnsr:     cnsr,
+				Evntdesc: evnt,
+				Cnsdtdsc: cnsdesc,
+			})
+		}
+	}
+	return adtte
+}
+
+// The values of a record in character form, in the order of adtteVars
+func (t *Adtterec) fields() []string {
+	return []string{
+		t.Studyid,
+		t.Usubjid,
+		t.Subjid,
+		t.Siteid,
+		CPUtils.StrP2Str(t.Trtp),
+		CPUtils.IntP2Str(t.Trtpn),
+		CPUtils.StrP2Str(t.Trta),
+		CPUtils.IntP2Str(t.Trtan),
+		t.Saffl,
+		t.Ittfl,
+		t.Paramcd,
+		t.Param,
+		strconv.Itoa(t.Paramn),
+		t.Startdt.Format("2006-01-02"),
+		t.Adt.Format("2006-01-02"),
+		strconv.Itoa(t.Aval),
+		strconv.Itoa(t.Cnsr),
+		t.Evntdesc,
+		CPUtils.StrP2Str(t.Cnsdtdsc),
+	}
+}
+
+// The value of a variable by name, as written to the CSV
+func (t *Adtterec) Get(name string) string {
+	return getVar(adtteVars, t.fields(), name)
+}
+
+// Derive ADTTE from the ADSL file and write it to CSV and,
+// if a file name is given, to a SAS transport file.
+func WriteADTTE(adslfile, outfile, xptfile *string) {
+	adtte := DeriveADTTE(ReadADSL(adslfile))
+
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fo.Close()
+
+	w := bufio.NewWriter(fo)
+	var rows [][]string
+	for _, t := range adtte {
+		f := t.fields()
+		rows = append(rows, f)
+		if _, err := w.WriteString(strings.Join(f, ",") + "\n"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	if xptfile != nil && *xptfile != "" {
+		if err := XPT.Write(xptfile, "ADTTE", "Time-to-Event Analysis Dataset", adtteVars, rows); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Read the ADTTE CSV into the same slice of structs
+func ReadADTTE(infile *string) []*Adtterec {
+	file, err := os.Open(*infile)
+	if err != nil {
+		panic(fmt.Sprintf("error opening %s: %v", *infile, err))
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	var adtte []*Adtterec
+	for scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "error reading from file:", err)
+			os.Exit(3)
+		}
+		s := strings.Split(scanner.Text(), ",")
+		pn, _ := strconv.Atoi(s[12])
+		startdt, _ := time.Parse("2006-01-02", s[13])
+		adt, _ := time.Parse("2006-01-02", s[14])
+		aval, _ := strconv.Atoi(s[15])
+		cnsr, _ := strconv.Atoi(s[16])
+		adtte = append(adtte, &Adtterec{
+			Studyid:  s[0],
+			Usubjid:  s[1],
+			Subjid:   s[2],
+			Siteid:   s[3],
+			Trtp:     CPUtils.Str2StrP(s[4]),
+			Trtpn:    CPUtils.Str2IntP(s[5]),
+			Trta:     CPUtils.Str2StrP(s[6]),
+			Trtan:    CPUtils.Str2IntP(s[7]),
+			Saffl:    s[8],
+			Ittfl:    s[9],
+			Paramcd:  s[10],
+			Param:    s[11],
+			Paramn:   pn,
+			Startdt:  startdt,
+			Adt:      adt,
+			Aval:     aval,
+			Cnsr:     cnsr,
+			Evntdesc: s[17],
+			Cnsdtdsc: CPUtils.Str2StrP(s[18]),
+		})
+	}
+	return adtte
+}
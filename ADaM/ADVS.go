@@ -0,0 +1,448 @@
+// ADaM vital signs analysis data set (ADVS) for the fictitious study.
+// One record per subject, parameter and analysis visit, derived from VS and ADSL.
+// Metadata :
+// - STUDYID  Char 6  Study Identifier
+// - USUBJID  Char 18 Unique Subject Identifier
+// - SUBJID   Char 6  Subject Identifier for the Study
+// - SITEID   Char 4  Study Site Identifier
+// - TRTP     Char 7  Planned Treatment (TRT01P)
+// - TRTPN    Num     Planned Treatment (N)
+// - TRTA     Char 7  Actual Treatment (TRT01A)
+// - TRTAN    Num     Actual Treatment (N)
+// - SAFFL    Char 1  Safety Population Flag
+// - ITTFL    Char 1  Intent-To-Treat Population Flag
+// - PARAMCD  Char 8  Parameter Code (VSTESTCD)
+// - PARAM    Char 40 Parameter, the test name with its standard unit
+// - PARAMN   Num     Parameter (N)
+// - AVISIT   Char 20 Analysis Visit (Screening, Visit 1 ... Visit 14, Worst Post-Baseline)
+// - AVISITN  Num     Analysis Visit (N), the visit number or 99 for the worst value
+// - ADT      Num     Analysis Date
+// - ADY      Num     Analysis Relative Day
+// - AVAL     Num     Analysis Value
+// - BASE     Num     Baseline Value
+// - CHG      Num     Change from Baseline
+// - PCHG     Num     Percent Change from Baseline
+// - ANRLO    Num     Analysis Normal Range Lower Limit
+// - ANRHI    Num     Analysis Normal Range Upper Limit
+// - ANRIND   Char 6  Analysis Reference Range Indicator (LOW, NORMAL, HIGH)
+// - BNRIND   Char 6  Baseline Reference Range Indicator
+// - ABLFL    Char 1  Baseline Record Flag
+// - ANL01FL  Char 1  Analysis Flag 01, the observed records used for analysis by visit
+// - DTYPE    Char 8  Derivation Type (blank, LOCF or WORST)
+//
+// AVAL is the standardized result (VSSTRESN); for the tests taken in triplicate
+// it is the average of the sitting readings at the visit.
+// The baseline is the last non-missing value at or before visit 1 (VSBLFL).
+// CHG and PCHG are only derived after baseline.
+// For ITT subjects, a missing post-baseline visit up to the last scheduled
+// visit is imputed by carrying forward the last non-missing post-baseline
+// value (DTYPE LOCF). The worst post-baseline value (DTYPE WORST) is the
+// highest, the lowest or the furthest outside the normal range, as set for
+// each test with its normal range (see VS.Worse); for blood pressures and
+// heart rate a low value can be as bad as a high one.
+// The normal ranges are those of the VS tests; parameters without a range
+// have no range indicators.
+package ADaM
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phil0lucas/GoForCP/CPUtils"
+	"github.com/phil0lucas/GoForCP/VS"
+	"github.com/phil0lucas/GoForCP/XPT"
+)
+
+// The ADVS record. Variables that may be missing are pointers.
+type Advsrec struct {
+	Studyid string
+	Usubjid string
+	Subjid  string
+	Siteid  string
+	Trtp    *string
+	Trtpn   *int
+	Trta    *string
+	Trtan   *int
+	Saffl   string
+	Ittfl   string
+	Paramcd string
+	Param   string
+	Paramn  int
+	Avisit  string
+	Avisitn int
+	Adt     *time.Time
+	Ady     *int
+	Aval    *float64
+	Base    *float64
+	Chg     *float64
+	Pchg    *float64
+	Anrlo   *float64
+	Anrhi   *float64
+	Anrind  string
+	Bnrind  string
+	Ablfl   string
+	Anl01fl string
+	Dtype   string
+}
+
+// Variable definitions for the transport file, in the order of the CSV
+var advsVars = []XPT.Var{
+	{Name: "STUDYID", Label: "Study Identifier", Length: 6},
+	{Name: "USUBJID", Label: "Unique Subject Identifier", Length: 18},
+	{Name: "SUBJID", Label: "Subject Identifier for the Study", Length: 6},
+	{Name: "SITEID", Label: "Study Site Identifier", Length: 4},
+	{Name: "TRTP", Label: "Planned Treatment", Length: 7},
+	{Name: "TRTPN", Label: "Planned Treatment (N)", Numeric: true},
+	{Name: "TRTA", Label: "Actual Treatment", Length: 7},
+	{Name: "TRTAN", Label: "Actual Treatment (N)", Numeric: true},
+	{Name: "SAFFL", Label: "Safety Population Flag", Length: 1},
+	{Name: "ITTFL", Label: "Intent-To-Treat Population Flag", Length: 1},
+	{Name: "PARAMCD", Label: "Parameter Code", Length: 8},
+	{Name: "PARAM", Label: "Parameter", Length: 40},
+	{Name: "PARAMN", Label: "Parameter (N)", Numeric: true},
+	{Name: "AVISIT", Label: "Analysis Visit", Length: 20},
+	{Name: "AVISITN", Label: "Analysis Visit (N)", Numeric: true},
+	{Name: "ADT", Label: "Analysis Date", Numeric: true, Format: "DATE9."},
+	{Name: "ADY", Label: "Analysis Relative Day", Numeric: true},
+	{Name: "AVAL", Label: "Analysis Value", Numeric: true},
+	{Name: "BASE", Label: "Baseline Value", Numeric: true},
+	{Name: "CHG", Label: "Change from Baseline", Numeric: true},
+	{Name: "PCHG", Label: "Percent Change from Baseline", Numeric: true},
+	{Name: "ANRLO", Label: "Analysis Normal Range Lower Limit", Numeric: true},
+	{Name: "ANRHI", Label: "Analysis Normal Range Upper Limit", Numeric: true},
+	{Name: "ANRIND", Label: "Analysis Reference Range Indicator", Length: 6},
+	{Name: "BNRIND", Label: "Baseline Reference Range Indicator", Length: 6},
+	{Name: "ABLFL", Label: "Baseline Record Flag", Length: 1},
+	{Name: "ANL01FL", Label: "Analysis Flag 01", Length: 1},
+	{Name: "DTYPE", Label: "Derivation Type", Length: 8},
+}
+
+// Parameters in display order
+var paramcds = []string{"SBP", "DBP", "HR", "HEIGHT", "WEIGHT", "BMI", "TEMP", "RESP"}
+
+const (
+	blVisit    = 1  // Baseline visit, as flagged by VSBLFL
+	lastVisit  = 14 // Last scheduled visit
+	worstVisit = 99 // AVISITN of the worst post-baseline records
+)
+
+// Analysis visit name of a visit number
+func avisit(visitnum int) string {
+	switch visitnum {
+	case 0:
+		return "Screening"
+	case worstVisit:
+		return "Worst Post-Baseline"
+	}
+	return "Visit " + strconv.Itoa(visitnum)
+}
+
+// The parameter number of a test code
+func paramn(paramcd string) int {
+	for i, p := range paramcds {
+		if p == paramcd {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Derive CHG and PCHG of a post-baseline record
+func (a *Advsrec) change() {
+	if a.Avisitn <= blVisit || a.Aval == nil || a.Base == nil {
+		return
+	}
+	chg := *a.Aval - *a.Base
+	a.Chg = &chg
+	if *a.Base != 0 {
+		pchg := chg / *a.Base * 100
+		a.Pchg = &pchg
+	}
+}
+
+// Key of a subject's parameter
+type paramKey struct {
+	Usubjid string
+	Paramcd string
+}
+
+// Derive ADVS from VS and ADSL.
+// Only subjects in ADSL are included; records with no test are dropped.
+func DeriveADVS(vs []*VS.Vsrec, adsl []*Adslrec) []*Advsrec {
+	subj := make(map[string]*Adslrec)
+	for _, a := range adsl {
+		subj[a.Usubjid] = a
+	}
+
+	// Observed records, one per subject, parameter and visit
+	byParam := make(map[paramKey][]*Advsrec)
+	var keys []paramKey
+	for _, v := range VS.AvgByVisit(vs, "SITTING") {
+		s := subj[v.Usubjid]
+		if s == nil || v.Vstestcd == "" {
+			continue
+		}
+		dt := v.Vsdtc
+		dy := v.Vsdy
+		var lo, hi *float64
+		if l, h, ok := VS.NormalRange(v.Vstestcd); ok {
+			lo, hi = &l, &h
+		}
+		a := &Advsrec{
+			Studyid: s.Studyid,
+			Usubjid: s.Usubjid,
+			Subjid:  s.Subjid,
+			Siteid:  s.Siteid,
+			Trtp:    s.Trt01p,
+			Trtpn:   s.Trt01pn,
+			Trta:    s.Trt01a,
+			Trtan:   s.Trt01an,
+			Saffl:   s.Saffl,
+			Ittfl:   s.Ittfl,
+			Paramcd: v.Vstestcd,
+			Param:   v.Vstest + " (" + CPUtils.StrP2Str(v.Vsstresu) + ")",
+			Paramn:  paramn(v.Vstestcd),
+			Avisit:  avisit(v.Visitnum),
+			Avisitn: v.Visitnum,
+			Adt:     &dt,
+			Ady:     &dy,
+			Aval:    v.Vsstresn,
+			Anrlo:   lo,
+			Anrhi:   hi,
+			Anrind:  VS.RangeInd(v.Vstestcd, v.Vsstresn),
+		}
+		k := paramKey{v.Usubjid, v.Vstestcd}
+		if _, ok := byParam[k]; !ok {
+			keys = append(keys, k)
+		}
+		byParam[k] = append(byParam[k], a)
+	}
+
+	var advs []*Advsrec
+	for _, k := range keys {
+		recs := byParam[k]
+		sort.Slice(recs, func(i, j int) bool { return recs[i].Avisitn < recs[j].Avisitn })
+
+		// Baseline is the last non-missing value up to the baseline visit
+		var bl *Advsrec
+		for _, a := range recs {
+			if a.Avisitn <= blVisit && a.Aval != nil {
+				bl = a
+			}
+		}
+		var base *float64
+		var bnrind string
+		if bl != nil {
+			bl.Ablfl = "Y"
+			base = bl.Aval
+			bnrind = bl.Anrind
+		}
+
+		// Observed records, keeping the post-baseline ones by visit for LOCF
+		observed := make(map[int]*Advsrec)
+		var worst *Advsrec
+		for _, a := range recs {
+			a.Base = base
+			a.Bnrind = bnrind
+			a.change()
+			if a.Aval != nil {
+				a.Anl01fl = "Y"
+				if a.Avisitn > blVisit {
+					observed[a.Avisitn] = a
+					if worst == nil || VS.Worse(a.Paramcd, *a.Aval, *worst.Aval) {
+						worst = a
+					}
+				}
+			}
+			advs = append(advs, a)
+		}
+
+		// Last observation carried forward for ITT subjects
+		if recs[0].Ittfl == "Y" && len(observed) > 0 {
+			var last *Advsrec
+			for v := blVisit + 1; v <= lastVisit; v++ {
+				if o, ok := observed[v]; ok {
+					last = o
+					continue
+				}
+				if last == nil {
+					continue
+				}
+				l := *last
+				l.Avisit, l.Avisitn = avisit(v), v
+				l.Ablfl, l.Anl01fl, l.Dtype = "", "", "LOCF"
+				advs = append(advs, &l)
+			}
+		}
+
+		// Worst post-baseline value
+		if worst != nil {
+			w := *worst
+			w.Avisit, w.Avisitn = avisit(worstVisit), worstVisit
+			w.Ablfl, w.Anl01fl, w.Dtype = "", "", "WORST"
+			advs = append(advs, &w)
+		}
+	}
+
+	sort.SliceStable(advs, func(i, j int) bool {
+		a, b := advs[i], advs[j]
+		if a.Usubjid != b.Usubjid {
+			return a.Usubjid < b.Usubjid
+		}
+		if a.Paramn != b.Paramn {
+			return a.Paramn < b.Paramn
+		}
+		if a.Avisitn != b.Avisitn {
+			return a.Avisitn < b.Avisitn
+		}
+		return a.Dtype < b.Dtype
+	})
+	return advs
+}
+
+// The values of a record in character form, in the order of advsVars
+func (a *Advsrec) fields() []string {
+	return []string{
+		a.Studyid,
+		a.Usubjid,
+		a.Subjid,
+		a.Siteid,
+		CPUtils.StrP2Str(a.Trtp),
+		CPUtils.IntP2Str(a.Trtpn),
+		CPUtils.StrP2Str(a.Trta),
+		CPUtils.IntP2Str(a.Trtan),
+		a.Saffl,
+		a.Ittfl,
+		a.Paramcd,
+		a.Param,
+		strconv.Itoa(a.Paramn),
+		a.Avisit,
+		strconv.Itoa(a.Avisitn),
+		CPUtils.DateP2Str(a.Adt),
+		CPUtils.IntP2Str(a.Ady),
+		CPUtils.FloatP2Str(a.Aval, 2),
+		CPUtils.FloatP2Str(a.Base, 2),
+		CPUtils.FloatP2Str(a.Chg, 2),
+		CPUtils.FloatP2Str(a.Pchg, 2),
+		CPUtils.FloatP2Str(a.Anrlo, 1),
+		CPUtils.FloatP2Str(a.Anrhi, 1),
+		a.Anrind,
+		a.Bnrind,
+		a.Ablfl,
+		a.Anl01fl,
+		a.Dtype,
+	}
+}
+
+// The value of a variable by name, as written to the CSV
+func (a *Advsrec) Get(name string) string {
+	return getVar(advsVars, a.fields(), name)
+}
+
+// Derive ADVS from the VS and ADSL files and write it to CSV and,
+// if a file name is given, to a SAS transport file.
+func WriteADVS(vsfile, adslfile, outfile, xptfile *string) {
+	advs := DeriveADVS(VS.ReadVS(vsfile), ReadADSL(adslfile))
+
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fo.Close()
+
+	w := bufio.NewWriter(fo)
+	var rows [][]string
+	for _, a := range advs {
+		f := a.fields()
+		rows = append(rows, f)
+		if _, err := w.WriteString(strings.Join(f, ",") + "\n"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	if xptfile != nil && *xptfile != "" {
+		if err := XPT.Write(xptfile, "ADVS", "Vital Signs Analysis Dataset", advsVars, rows); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Read the ADVS CSV into the same slice of structs
+func ReadADVS(infile *string) []*Advsrec {
+	file, err := os.Open(*infile)
+	if err != nil {
+		panic(fmt.Sprintf("error opening %s: %v", *infile, err))
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	var advs []*Advsrec
+	for scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "error reading from file:", err)
+			os.Exit(3)
+		}
+		s := strings.Split(scanner.Text(), ",")
+		pn, _ := strconv.Atoi(s[12])
+		avisitn, _ := strconv.Atoi(s[14])
+		advs = append(advs, &Advsrec{
+			Studyid: s[0],
+			Usubjid: s[1],
+			Subjid:  s[2],
+			Siteid:  s[3],
+			Trtp:    CPUtils.Str2StrP(s[4]),
+			Trtpn:   CPUtils.Str2IntP(s[5]),
+			Trta:    CPUtils.Str2StrP(s[6]),
+			Trtan:   CPUtils.Str2IntP(s[7]),
+			Saffl:   s[8],
+			Ittfl:   s[9],
+			Paramcd: s[10],
+			Param:   s[11],
+			Paramn:  pn,
+			Avisit:  s[13],
+			Avisitn: avisitn,
+			Adt:     CPUtils.Str2DateP(s[15]),
+			Ady:     CPUtils.Str2IntP(s[16]),
+			Aval:    CPUtils.Str2FloatP(s[17]),
+			Base:    CPUtils.Str2FloatP(s[18]),
+			Chg:     CPUtils.Str2FloatP(s[19]),
+			Pchg:    CPUtils.Str2FloatP(s[20]),
+			Anrlo:   CPUtils.Str2FloatP(s[21]),
+			Anrhi:   CPUtils.Str2FloatP(s[22]),
+			Anrind:  s[23],
+			Bnrind:  s[24],
+			Ablfl:   s[25],
+			Anl01fl: s[26],
+			Dtype:   s[27],
+		})
+	}
+	return advs
+}
+
+// Select the records of a parameter with the given derivation type.
+// Observed records (blank dtype) are those flagged for analysis (ANL01FL).
+// Records for subjects not in the population (ADSL flag name) are excluded.
+func SelectADVS(advs []*Advsrec, paramcd string, dtype string, popfl string) []*Advsrec {
+	var out []*Advsrec
+	for _, a := range advs {
+		if a.Paramcd != paramcd || a.Dtype != dtype {
+			continue
+		}
+		if dtype == "" && a.Anl01fl != "Y" {
+			continue
+		}
+		if (popfl == "SAFFL" && a.Saffl != "Y") || (popfl == "ITTFL" && a.Ittfl != "Y") {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
@@ -0,0 +1,190 @@
+package CPStats
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// An observation for analysis of covariance: the response, the treatment
+// group, the covariate and an optional stratum (e.g. the site).
+type AncovaObs struct {
+	Y       float64
+	Group   string
+	Cov     float64
+	Stratum string
+}
+
+// A least squares mean of a group
+type LSMean struct {
+	Group string
+	N     int
+	Est   float64
+	SE    float64
+	Lower float64
+	Upper float64
+}
+
+// The difference of the LS means of a group and the reference group
+type LSDiff struct {
+	Group string
+	Ref   string
+	Est   float64
+	SE    float64
+	Lower float64
+	Upper float64
+	T     float64
+	P     float64
+}
+
+// The results of an ANCOVA fit
+type Ancova struct {
+	N      int
+	DF     int     // Error degrees of freedom
+	MSE    float64 // Mean square error
+	Slope  float64 // Coefficient of the covariate
+	LSMean []LSMean
+	Diff   []LSDiff
+}
+
+// Errors from the ANCOVA fit
+var ErrTooFewObs = errors.New("too few observations for the model")
+
+// Sorted unique values, with first (if present) placed first
+func levels(values []string, first string) []string {
+	seen := make(map[string]bool)
+	var l []string
+	for _, v := range values {
+		if !seen[v] && v != first {
+			seen[v] = true
+			l = append(l, v)
+		}
+	}
+	sort.Strings(l)
+	for _, v := range values {
+		if v == first {
+			return append([]string{first}, l...)
+		}
+	}
+	return l
+}
+
+// Fit the ANCOVA model Y = group + covariate [+ stratum] by ordinary least
+// squares, with reference cell coding of the classification effects.
+// LS means are estimated at the mean of the covariate, averaging equally
+// over the strata as in SAS PROC GLM. Each group is compared with the
+// reference group; confidence limits are 100(1-alpha)%.
+func FitAncova(obs []AncovaObs, ref string, strata bool, alpha float64) (*Ancova, error) {
+	var groups, strats []string
+	var covMean float64
+	for _, o := range obs {
+		groups = append(groups, o.Group)
+		strats = append(strats, o.Stratum)
+		covMean += o.Cov
+	}
+	if len(obs) == 0 {
+		return nil, ErrTooFewObs
+	}
+	covMean /= float64(len(obs))
+	gl := levels(groups, ref)
+	sl := levels(strats, "")
+	if !strata {
+		sl = sl[:1]
+	}
+
+	// Columns: intercept, groups (excluding the first), strata (excluding the first), covariate
+	p := len(gl) + len(sl)
+	row := func(g string, s string, cov float64) []float64 {
+		x := make([]float64, p)
+		x[0] = 1
+		for i, v := range gl[1:] {
+			if v == g {
+				x[1+i] = 1
+			}
+		}
+		for i, v := range sl[1:] {
+			if v == s {
+				x[len(gl)+i] = 1
+			}
+		}
+		x[p-1] = cov
+		return x
+	}
+
+	xtx := NewMatrix(p, p)
+	xty := make([]float64, p)
+	for _, o := range obs {
+		x := row(o.Group, o.Stratum, o.Cov)
+		for i := range x {
+			xty[i] += x[i] * o.Y
+			for j := range x {
+				xtx[i][j] += x[i] * x[j]
+			}
+		}
+	}
+	df := len(obs) - p
+	if df < 1 {
+		return nil, ErrTooFewObs
+	}
+	inv, err := Inverse(xtx)
+	if err != nil {
+		return nil, fmt.Errorf("ANCOVA: %v", err)
+	}
+	beta := make([]float64, p)
+	for i := range beta {
+		for j := range xty {
+			beta[i] += inv[i][j] * xty[j]
+		}
+	}
+	var sse float64
+	for _, o := range obs {
+		x := row(o.Group, o.Stratum, o.Cov)
+		var fit float64
+		for i := range x {
+			fit += x[i] * beta[i]
+		}
+		sse += (o.Y - fit) * (o.Y - fit)
+	}
+	mse := sse / float64(df)
+	tq := TQuantile(1-alpha/2, float64(df))
+
+	a := &Ancova{N: len(obs), DF: df, MSE: mse, Slope: beta[p-1]}
+
+	// Coefficients of the LS mean of a group: strata averaged equally,
+	// covariate at its mean
+	lsm := func(g string) []float64 {
+		l := row(g, "", covMean)
+		for i := range sl[1:] {
+			l[len(gl)+i] = 1 / float64(len(sl))
+		}
+		return l
+	}
+	est := func(l []float64) (float64, float64) {
+		var e float64
+		for i := range l {
+			e += l[i] * beta[i]
+		}
+		return e, math.Sqrt(mse * QuadForm(l, inv, l))
+	}
+
+	n := make(map[string]int)
+	for _, o := range obs {
+		n[o.Group]++
+	}
+	for _, g := range gl {
+		e, se := est(lsm(g))
+		a.LSMean = append(a.LSMean, LSMean{g, n[g], e, se, e - tq*se, e + tq*se})
+	}
+	for _, g := range gl[1:] {
+		lg, lr := lsm(g), lsm(gl[0])
+		l := make([]float64, p)
+		for i := range l {
+			l[i] = lg[i] - lr[i]
+		}
+		e, se := est(l)
+		t := e / se
+		a.Diff = append(a.Diff, LSDiff{g, gl[0], e, se, e - tq*se, e + tq*se, t, TP(t, float64(df))})
+	}
+	return a, nil
+}
@@ -0,0 +1,171 @@
+package CPStats
+
+import (
+	"math"
+	"sort"
+)
+
+// Descriptive statistics of a sample. Statistics that are not defined for
+// the sample (e.g. the SD of a single value, or the geometric mean of
+// values that are not all positive) are returned as NaN.
+
+// Default quantile definition, as SAS QNTLDEF=5
+const DefaultQntlDef = 5
+
+// Arithmetic mean
+func Mean(x []float64) float64 {
+	if len(x) == 0 {
+		return math.NaN()
+	}
+	m, _ := meanVar(x)
+	return m
+}
+
+// Sample standard deviation, with divisor n-1
+func SD(x []float64) float64 {
+	if len(x) < 2 {
+		return math.NaN()
+	}
+	_, v := meanVar(x)
+	return math.Sqrt(v)
+}
+
+// Minimum and maximum
+func MinMax(x []float64) (float64, float64) {
+	if len(x) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	min, max := x[0], x[0]
+	for _, v := range x {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	return min, max
+}
+
+// The p quantile (0 < p < 1) by a SAS quantile definition (QNTLDEF):
+// 1 weighted average at x(np), 2 observation numbered closest to np,
+// 3 empirical distribution function, 4 weighted average at x((n+1)p),
+// 5 empirical distribution function with averaging.
+// Any other definition is taken as 5.
+func Quantile(x []float64, p float64, def int) float64 {
+	n := len(x)
+	if n == 0 {
+		return math.NaN()
+	}
+	s := append([]float64(nil), x...)
+	sort.Float64s(s)
+
+	// The observation numbered i (1 based), the first or last beyond the ends
+	obs := func(i int) float64 {
+		if i < 1 {
+			return s[0]
+		}
+		if i > n {
+			return s[n-1]
+		}
+		return s[i-1]
+	}
+	np := float64(n) * p
+	if def == 4 {
+		np = float64(n+1) * p
+	}
+	jf := math.Floor(np)
+	g := np - jf
+	// Fuzz the fraction so that np exact in decimal is treated as whole
+	if g < 1e-9 {
+		g = 0
+	} else if g > 1-1e-9 {
+		jf, g = jf+1, 0
+	}
+	j := int(jf)
+
+	switch def {
+	case 1, 4:
+		return (1-g)*obs(j) + g*obs(j+1)
+	case 2:
+		if g == 0.5 {
+			if j%2 == 0 {
+				return obs(j)
+			}
+			return obs(j + 1)
+		}
+		return obs(int(math.Floor(np + 0.5)))
+	case 3:
+		if g == 0 {
+			return obs(j)
+		}
+		return obs(j + 1)
+	}
+	if g == 0 {
+		return (obs(j) + obs(j+1)) / 2
+	}
+	return obs(j + 1)
+}
+
+// Median by a quantile definition
+func Median(x []float64, def int) float64 {
+	return Quantile(x, 0.5, def)
+}
+
+// Lower and upper quartiles by a quantile definition
+func Quartiles(x []float64, def int) (float64, float64) {
+	return Quantile(x, 0.25, def), Quantile(x, 0.75, def)
+}
+
+// Interquartile range, Q3 - Q1
+func IQR(x []float64, def int) float64 {
+	q1, q3 := Quartiles(x, def)
+	return q3 - q1
+}
+
+// 100(1-alpha)% confidence limits of the mean, from the t distribution
+func MeanCI(x []float64, alpha float64) (float64, float64) {
+	if len(x) < 2 {
+		return math.NaN(), math.NaN()
+	}
+	m, v := meanVar(x)
+	h := TQuantile(1-alpha/2, float64(len(x)-1)) * math.Sqrt(v/float64(len(x)))
+	return m - h, m + h
+}
+
+// Coefficient of variation, the SD as a percentage of the mean
+func CV(x []float64) float64 {
+	m := Mean(x)
+	if m == 0 {
+		return math.NaN()
+	}
+	return SD(x) / m * 100
+}
+
+// The natural logarithms of the values, false unless all are positive
+func logs(x []float64) ([]float64, bool) {
+	l := make([]float64, len(x))
+	for i, v := range x {
+		if v <= 0 {
+			return nil, false
+		}
+		l[i] = math.Log(v)
+	}
+	return l, true
+}
+
+// Geometric mean, of positive values
+func GeoMean(x []float64) float64 {
+	l, ok := logs(x)
+	if !ok {
+		return math.NaN()
+	}
+	return math.Exp(Mean(l))
+}
+
+// Geometric coefficient of variation (%), sqrt(exp(s*s) - 1) * 100 where
+// s is the SD of the natural logarithms of the positive values
+func GeoCV(x []float64) float64 {
+	l, ok := logs(x)
+	if !ok {
+		return math.NaN()
+	}
+	s := SD(l)
+	return math.Sqrt(math.Exp(s*s)-1) * 100
+}
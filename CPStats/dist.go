@@ -0,0 +1,208 @@
+// Statistical functions for clinical reporting.
+//
+// Probability distributions are computed from the special functions in
+// Numerical Recipes style: the chi-square distribution from the regularized
+// incomplete gamma function, the t and F distributions from the regularized
+// incomplete beta function and the normal distribution from the error function.
+package CPStats
+
+import (
+	"math"
+)
+
+// Convergence settings for the series and continued fractions
+const (
+	maxIter = 500
+	eps     = 1e-14
+	fpmin   = 1e-300
+)
+
+// Standard normal cumulative distribution function
+func NormalCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+// Quantile of the standard normal distribution, e.g. NormalQuantile(0.975) = 1.96
+func NormalQuantile(p float64) float64 {
+	return -math.Sqrt2 * math.Erfcinv(2*p)
+}
+
+// Regularized lower incomplete gamma function P(a, x)
+func gammaP(a, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x < a+1 {
+		return gammaSeries(a, x)
+	}
+	return 1 - gammaCF(a, x)
+}
+
+// P(a, x) by its series representation
+func gammaSeries(a, x float64) float64 {
+	lg, _ := math.Lgamma(a)
+	ap := a
+	sum := 1 / a
+	del := sum
+	for n := 0; n < maxIter; n++ {
+		ap++
+		del *= x / ap
+		sum += del
+		if math.Abs(del) < math.Abs(sum)*eps {
+			break
+		}
+	}
+	return sum * math.Exp(-x+a*math.Log(x)-lg)
+}
+
+// Q(a, x) = 1 - P(a, x) by its continued fraction (modified Lentz)
+func gammaCF(a, x float64) float64 {
+	lg, _ := math.Lgamma(a)
+	b := x + 1 - a
+	c := 1 / fpmin
+	d := 1 / b
+	h := d
+	for i := 1; i <= maxIter; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < fpmin {
+			d = fpmin
+		}
+		c = b + an/c
+		if math.Abs(c) < fpmin {
+			c = fpmin
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lg) * h
+}
+
+// Chi-square cumulative distribution function with df degrees of freedom
+func ChiSqCDF(x float64, df float64) float64 {
+	return gammaP(df/2, x/2)
+}
+
+// Upper tail probability of the chi-square distribution, i.e. the p-value of a statistic x
+func ChiSqP(x float64, df float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+	if x < df/2+1 {
+		return 1 - gammaSeries(df/2, x/2)
+	}
+	return gammaCF(df/2, x/2)
+}
+
+// Regularized incomplete beta function I_x(a, b)
+func betaI(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	bt := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return bt * betaCF(a, b, x) / a
+	}
+	return 1 - bt*betaCF(b, a, 1-x)/b
+}
+
+// Continued fraction for the incomplete beta function (modified Lentz)
+func betaCF(a, b, x float64) float64 {
+	qab, qap, qam := a+b, a+1, a-1
+	c := 1.0
+	d := 1 - qab*x/qap
+	if math.Abs(d) < fpmin {
+		d = fpmin
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIter; m++ {
+		fm := float64(m)
+		m2 := 2 * fm
+		aa := fm * (b - fm) * x / ((qam + m2) * (a + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < fpmin {
+			d = fpmin
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < fpmin {
+			c = fpmin
+		}
+		d = 1 / d
+		h *= d * c
+		aa = -(a + fm) * (qab + fm) * x / ((a + m2) * (qap + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < fpmin {
+			d = fpmin
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < fpmin {
+			c = fpmin
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return h
+}
+
+// Student's t cumulative distribution function with df degrees of freedom
+func TCDF(t float64, df float64) float64 {
+	p := 0.5 * betaI(df/2, 0.5, df/(df+t*t))
+	if t > 0 {
+		return 1 - p
+	}
+	return p
+}
+
+// Two-sided p-value of a t statistic
+func TP(t float64, df float64) float64 {
+	return betaI(df/2, 0.5, df/(df+t*t))
+}
+
+// Quantile of Student's t distribution, by bisection on the CDF
+func TQuantile(p float64, df float64) float64 {
+	lo, hi := -1e3, 1e3
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		if TCDF(mid, df) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+		if hi-lo < 1e-12 {
+			break
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// F cumulative distribution function with df1 and df2 degrees of freedom
+func FCDF(f float64, df1, df2 float64) float64 {
+	if f <= 0 {
+		return 0
+	}
+	return betaI(df1/2, df2/2, df1*f/(df1*f+df2))
+}
+
+// Upper tail probability of the F distribution, i.e. the p-value of a statistic f
+func FP(f float64, df1, df2 float64) float64 {
+	if f <= 0 {
+		return 1
+	}
+	return betaI(df2/2, df1/2, df2/(df2+df1*f))
+}
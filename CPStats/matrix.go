@@ -0,0 +1,156 @@
+package CPStats
+
+import (
+	"errors"
+	"math"
+)
+
+// Matrices are held as slices of rows
+type Matrix [][]float64
+
+// Errors from the matrix functions
+var (
+	ErrSingular = errors.New("matrix is singular")
+	ErrNotPD    = errors.New("matrix is not positive definite")
+)
+
+// A zero matrix with r rows and c columns
+func NewMatrix(r, c int) Matrix {
+	m := make(Matrix, r)
+	for i := range m {
+		m[i] = make([]float64, c)
+	}
+	return m
+}
+
+// A copy of the matrix
+func (m Matrix) Copy() Matrix {
+	c := make(Matrix, len(m))
+	for i := range m {
+		c[i] = append([]float64(nil), m[i]...)
+	}
+	return c
+}
+
+// Inverse of a square matrix by Gauss-Jordan elimination with partial pivoting
+func Inverse(m Matrix) (Matrix, error) {
+	n := len(m)
+	a := m.Copy()
+	inv := NewMatrix(n, n)
+	for i := range inv {
+		inv[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		// Pivot on the largest remaining value in the column
+		piv := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[piv][col]) {
+				piv = r
+			}
+		}
+		if math.Abs(a[piv][col]) < 1e-12 {
+			return nil, ErrSingular
+		}
+		a[col], a[piv] = a[piv], a[col]
+		inv[col], inv[piv] = inv[piv], inv[col]
+
+		p := a[col][col]
+		for j := 0; j < n; j++ {
+			a[col][j] /= p
+			inv[col][j] /= p
+		}
+		for r := 0; r < n; r++ {
+			if r == col || a[r][col] == 0 {
+				continue
+			}
+			f := a[r][col]
+			for j := 0; j < n; j++ {
+				a[r][j] -= f * a[col][j]
+				inv[r][j] -= f * inv[col][j]
+			}
+		}
+	}
+	return inv, nil
+}
+
+// Quadratic form x' M y
+func QuadForm(x []float64, m Matrix, y []float64) float64 {
+	var s float64
+	for i := range x {
+		for j := range y {
+			s += x[i] * m[i][j] * y[j]
+		}
+	}
+	return s
+}
+
+// Cholesky decomposition of a symmetric positive definite matrix,
+// returning the lower triangular factor
+func Cholesky(m Matrix) (Matrix, error) {
+	n := len(m)
+	l := NewMatrix(n, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			s := m[i][j]
+			for k := 0; k < j; k++ {
+				s -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if s <= 0 {
+					return nil, ErrNotPD
+				}
+				l[i][i] = math.Sqrt(s)
+			} else {
+				l[i][j] = s / l[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// Inverse and log determinant of a symmetric positive definite matrix
+func InverseSPD(m Matrix) (Matrix, float64, error) {
+	l, err := Cholesky(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := len(m)
+	var logdet float64
+	for i := 0; i < n; i++ {
+		logdet += 2 * math.Log(l[i][i])
+	}
+	// Invert L by forward substitution, then inv = L^-T L^-1
+	li := NewMatrix(n, n)
+	for i := 0; i < n; i++ {
+		li[i][i] = 1 / l[i][i]
+		for j := 0; j < i; j++ {
+			var s float64
+			for k := j; k < i; k++ {
+				s -= l[i][k] * li[k][j]
+			}
+			li[i][j] = s / l[i][i]
+		}
+	}
+	inv := NewMatrix(n, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			var s float64
+			for k := i; k < n; k++ {
+				s += li[k][i] * li[k][j]
+			}
+			inv[i][j], inv[j][i] = s, s
+		}
+	}
+	return inv, logdet, nil
+}
+
+// Matrix-vector product
+func (m Matrix) MulVec(v []float64) []float64 {
+	out := make([]float64, len(m))
+	for i := range m {
+		for j := range v {
+			out[i] += m[i][j] * v[j]
+		}
+	}
+	return out
+}
@@ -0,0 +1,634 @@
+package CPStats
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Covariance structures of the repeated measures within a subject
+const (
+	UN  = "UN"    // Unstructured
+	AR1 = "AR(1)" // First order autoregressive
+	CS  = "CS"    // Compound symmetry
+)
+
+// Errors from the mixed model fit
+var (
+	ErrNoConverge = errors.New("REML iterations did not converge")
+	ErrStructure  = errors.New("unknown covariance structure")
+)
+
+// Iteration settings of the REML fit
+const (
+	remlMaxIter = 100
+	remlTol     = 1e-8
+)
+
+// The data of a subject for a mixed model: the responses, the index of the
+// visit (0 to the number of visits-1) of each response and the rows of the
+// fixed effects design matrix.
+type MixedSubject struct {
+	Y     []float64
+	Visit []int
+	X     [][]float64
+}
+
+// The REML fit of a linear model with correlated errors within subjects
+type MixedFit struct {
+	Structure string
+	Theta     []float64 // Covariance parameters
+	Sigma     Matrix    // Covariance matrix over the visits
+	Beta      []float64 // Fixed effects
+	CovBeta   Matrix    // Covariance matrix of Beta, (X'V^-1X)^-1
+	NegTwoLL  float64   // -2 residual log likelihood
+	NObs      int
+	DF        int // Residual degrees of freedom
+	Iter      int
+}
+
+// A covariance structure over t visits, parameterized by theta
+type covStruct interface {
+	sigma(theta []float64, t int) Matrix
+	deriv(theta []float64, t int) []Matrix
+	start(s Matrix) []float64
+}
+
+// Unstructured: theta holds the lower triangle by row
+type unStruct struct{}
+
+func (unStruct) sigma(theta []float64, t int) Matrix {
+	s := NewMatrix(t, t)
+	k := 0
+	for i := 0; i < t; i++ {
+		for j := 0; j <= i; j++ {
+			s[i][j], s[j][i] = theta[k], theta[k]
+			k++
+		}
+	}
+	return s
+}
+
+func (unStruct) deriv(theta []float64, t int) []Matrix {
+	var d []Matrix
+	for i := 0; i < t; i++ {
+		for j := 0; j <= i; j++ {
+			m := NewMatrix(t, t)
+			m[i][j], m[j][i] = 1, 1
+			d = append(d, m)
+		}
+	}
+	return d
+}
+
+func (unStruct) start(s Matrix) []float64 {
+	var theta []float64
+	for i := range s {
+		for j := 0; j <= i; j++ {
+			theta = append(theta, s[i][j])
+		}
+	}
+	return theta
+}
+
+// AR(1): theta is the variance and the correlation of adjacent visits
+type ar1Struct struct{}
+
+func (ar1Struct) sigma(theta []float64, t int) Matrix {
+	s := NewMatrix(t, t)
+	for i := 0; i < t; i++ {
+		for j := 0; j < t; j++ {
+			s[i][j] = theta[0] * math.Pow(theta[1], math.Abs(float64(i-j)))
+		}
+	}
+	return s
+}
+
+func (ar1Struct) deriv(theta []float64, t int) []Matrix {
+	dv, dr := NewMatrix(t, t), NewMatrix(t, t)
+	for i := 0; i < t; i++ {
+		for j := 0; j < t; j++ {
+			lag := math.Abs(float64(i - j))
+			dv[i][j] = math.Pow(theta[1], lag)
+			if lag > 0 {
+				dr[i][j] = theta[0] * lag * math.Pow(theta[1], lag-1)
+			}
+		}
+	}
+	return []Matrix{dv, dr}
+}
+
+func (ar1Struct) start(s Matrix) []float64 {
+	var v, r float64
+	for i := range s {
+		v += s[i][i]
+	}
+	v /= float64(len(s))
+	for i := 1; i < len(s); i++ {
+		r += s[i][i-1] / v
+	}
+	if len(s) > 1 {
+		r /= float64(len(s) - 1)
+	}
+	return []float64{v, math.Max(0.05, math.Min(0.9, r))}
+}
+
+// Compound symmetry: theta is the between-subject covariance and the residual variance
+type csStruct struct{}
+
+func (csStruct) sigma(theta []float64, t int) Matrix {
+	s := NewMatrix(t, t)
+	for i := 0; i < t; i++ {
+		for j := 0; j < t; j++ {
+			s[i][j] = theta[0]
+		}
+		s[i][i] += theta[1]
+	}
+	return s
+}
+
+func (csStruct) deriv(theta []float64, t int) []Matrix {
+	dj, di := NewMatrix(t, t), NewMatrix(t, t)
+	for i := 0; i < t; i++ {
+		for j := 0; j < t; j++ {
+			dj[i][j] = 1
+		}
+		di[i][i] = 1
+	}
+	return []Matrix{dj, di}
+}
+
+func (csStruct) start(s Matrix) []float64 {
+	var v, c float64
+	var n int
+	for i := range s {
+		v += s[i][i]
+		for j := 0; j < i; j++ {
+			c += s[i][j]
+			n++
+		}
+	}
+	v /= float64(len(s))
+	if n > 0 {
+		c /= float64(n)
+	}
+	c = math.Max(0.1*v, math.Min(0.9*v, c))
+	return []float64{c, v - c}
+}
+
+// The structure of a name
+func structure(name string) (covStruct, error) {
+	switch name {
+	case UN:
+		return unStruct{}, nil
+	case AR1:
+		return ar1Struct{}, nil
+	case CS:
+		return csStruct{}, nil
+	}
+	return nil, ErrStructure
+}
+
+// The rows and columns of a matrix for the visits of a subject
+func sub(m Matrix, idx []int) Matrix {
+	s := NewMatrix(len(idx), len(idx))
+	for i, a := range idx {
+		for j, b := range idx {
+			s[i][j] = m[a][b]
+		}
+	}
+	return s
+}
+
+// The REML log likelihood and its derivatives at theta
+type remlEval struct {
+	ll    float64
+	beta  []float64
+	c     Matrix // (X'V^-1X)^-1
+	score []float64
+	ai    Matrix // Average information matrix
+}
+
+// Evaluate the REML log likelihood at theta, with the score and average
+// information if derivs is set
+func evalREML(subj []MixedSubject, t int, p int, cs covStruct, theta []float64, derivs bool) (*remlEval, error) {
+	sigma := cs.sigma(theta, t)
+	w := make([]Matrix, len(subj))
+	var logdet float64
+	xtwx := NewMatrix(p, p)
+	xtwy := make([]float64, p)
+	n := 0
+	for i, s := range subj {
+		wi, ld, err := InverseSPD(sub(sigma, s.Visit))
+		if err != nil {
+			return nil, err
+		}
+		w[i] = wi
+		logdet += ld
+		n += len(s.Y)
+		wx := make([][]float64, len(s.Y)) // W_i X_i
+		for a := range s.Y {
+			wx[a] = make([]float64, p)
+			for b := range s.Y {
+				for k := 0; k < p; k++ {
+					wx[a][k] += wi[a][b] * s.X[b][k]
+				}
+			}
+		}
+		for a := range s.Y {
+			for k := 0; k < p; k++ {
+				xtwy[k] += wx[a][k] * s.Y[a]
+				for l := 0; l < p; l++ {
+					xtwx[k][l] += s.X[a][k] * wx[a][l]
+				}
+			}
+		}
+	}
+	c, ldx, err := InverseSPD(xtwx)
+	if err != nil {
+		return nil, fmt.Errorf("fixed effects: %v", err)
+	}
+	beta := c.MulVec(xtwy)
+
+	// Residuals r and u = W r for each subject
+	u := make([][]float64, len(subj))
+	var quad float64
+	for i, s := range subj {
+		r := make([]float64, len(s.Y))
+		for a := range s.Y {
+			r[a] = s.Y[a]
+			for k := 0; k < p; k++ {
+				r[a] -= s.X[a][k] * beta[k]
+			}
+		}
+		u[i] = w[i].MulVec(r)
+		for a := range r {
+			quad += r[a] * u[i][a]
+		}
+	}
+	e := &remlEval{
+		ll:   -0.5 * (logdet + ldx + quad + float64(n-p)*math.Log(2*math.Pi)),
+		beta: beta,
+		c:    c,
+	}
+	if !derivs {
+		return e, nil
+	}
+
+	// Accumulate W - W X C X' W and u u' over the visits
+	d := cs.deriv(theta, t)
+	q := NewMatrix(t, t)
+	uu := NewMatrix(t, t)
+	for i, s := range subj {
+		wx := make([][]float64, len(s.Y))
+		for a := range s.Y {
+			wx[a] = make([]float64, p)
+			for b := range s.Y {
+				for k := 0; k < p; k++ {
+					wx[a][k] += w[i][a][b] * s.X[b][k]
+				}
+			}
+		}
+		for a, va := range s.Visit {
+			cw := c.MulVec(wx[a])
+			for b, vb := range s.Visit {
+				var m float64
+				for k := 0; k < p; k++ {
+					m += wx[b][k] * cw[k]
+				}
+				q[va][vb] += w[i][a][b] - m
+				uu[va][vb] += u[i][a] * u[i][b]
+			}
+		}
+	}
+	e.score = make([]float64, len(d))
+	for k, dk := range d {
+		for a := 0; a < t; a++ {
+			for b := 0; b < t; b++ {
+				e.score[k] += dk[a][b] * (q[a][b] - uu[a][b])
+			}
+		}
+		e.score[k] *= -0.5
+	}
+
+	// Average information: 0.5 y'P dV_k P dV_l P y, from a_k = dV_k P y
+	// and b_k = V^-1 a_k, projecting out the fixed effects through g_k = X' b_k
+	a := make([][][]float64, len(d))
+	b := make([][][]float64, len(d))
+	g := make([][]float64, len(d))
+	for k, dk := range d {
+		a[k] = make([][]float64, len(subj))
+		b[k] = make([][]float64, len(subj))
+		g[k] = make([]float64, p)
+		for i, s := range subj {
+			a[k][i] = sub(dk, s.Visit).MulVec(u[i])
+			b[k][i] = w[i].MulVec(a[k][i])
+			for r := range s.Y {
+				for l := 0; l < p; l++ {
+					g[k][l] += s.X[r][l] * b[k][i][r]
+				}
+			}
+		}
+	}
+	e.ai = NewMatrix(len(d), len(d))
+	for k := range d {
+		for l := 0; l <= k; l++ {
+			var v float64
+			for i := range subj {
+				for r := range a[k][i] {
+					v += a[k][i][r] * b[l][i][r]
+				}
+			}
+			v -= QuadForm(g[k], c, g[l])
+			e.ai[k][l], e.ai[l][k] = 0.5*v, 0.5*v
+		}
+	}
+	return e, nil
+}
+
+// Starting covariance over the visits from the residuals of the
+// ordinary least squares fit, using the pairs of visits available
+func startCov(subj []MixedSubject, t int, p int) Matrix {
+	xtx := NewMatrix(p, p)
+	xty := make([]float64, p)
+	for _, s := range subj {
+		for a := range s.Y {
+			for k := 0; k < p; k++ {
+				xty[k] += s.X[a][k] * s.Y[a]
+				for l := 0; l < p; l++ {
+					xtx[k][l] += s.X[a][k] * s.X[a][l]
+				}
+			}
+		}
+	}
+	inv, err := Inverse(xtx)
+	beta := make([]float64, p)
+	if err == nil {
+		beta = inv.MulVec(xty)
+	}
+	sum := NewMatrix(t, t)
+	n := NewMatrix(t, t)
+	for _, s := range subj {
+		r := make([]float64, len(s.Y))
+		for a := range s.Y {
+			r[a] = s.Y[a]
+			for k := 0; k < p; k++ {
+				r[a] -= s.X[a][k] * beta[k]
+			}
+		}
+		for a, va := range s.Visit {
+			for b, vb := range s.Visit {
+				sum[va][vb] += r[a] * r[b]
+				n[va][vb]++
+			}
+		}
+	}
+	for a := 0; a < t; a++ {
+		for b := 0; b < t; b++ {
+			if n[a][b] > 1 {
+				sum[a][b] /= n[a][b] - 1
+			} else if a == b {
+				sum[a][b] = 1
+			} else {
+				sum[a][b] = 0
+			}
+		}
+	}
+	if _, err := Cholesky(sum); err != nil {
+		// Fall back to the variances alone
+		for a := 0; a < t; a++ {
+			for b := 0; b < t; b++ {
+				if a != b {
+					sum[a][b] = 0
+				}
+			}
+		}
+	}
+	return sum
+}
+
+// Fit a linear model with correlated errors over t visits within subjects
+// by REML, using average information (AI) Newton-Raphson iterations with
+// step halving.
+func FitMixed(subj []MixedSubject, t int, name string) (*MixedFit, error) {
+	cs, err := structure(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(subj) == 0 || len(subj[0].X) == 0 {
+		return nil, ErrTooFewObs
+	}
+	p := len(subj[0].X[0])
+	theta := cs.start(startCov(subj, t, p))
+
+	e, err := evalREML(subj, t, p, cs, theta, true)
+	if err != nil {
+		return nil, err
+	}
+	var iter int
+	converged := false
+	for iter = 1; iter <= remlMaxIter; iter++ {
+		inv, err := Inverse(e.ai)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		delta := inv.MulVec(e.score)
+
+		// Halve the step until the likelihood does not decrease
+		var next *remlEval
+		var nt []float64
+		step := 1.0
+		for h := 0; h < 30; h++ {
+			nt = make([]float64, len(theta))
+			for k := range theta {
+				nt[k] = theta[k] + step*delta[k]
+			}
+			if ne, err := evalREML(subj, t, p, cs, nt, true); err == nil && validTheta(name, nt) && ne.ll >= e.ll-1e-10 {
+				next = ne
+				break
+			}
+			step /= 2
+		}
+		if next == nil {
+			break
+		}
+		change := math.Abs(next.ll - e.ll)
+		theta, e = nt, next
+		if change < remlTol*(1+math.Abs(e.ll)) {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return nil, fmt.Errorf("%s: %v", name, ErrNoConverge)
+	}
+
+	n := 0
+	for _, s := range subj {
+		n += len(s.Y)
+	}
+	return &MixedFit{
+		Structure: name,
+		Theta:     theta,
+		Sigma:     cs.sigma(theta, t),
+		Beta:      e.beta,
+		CovBeta:   e.c,
+		NegTwoLL:  -2 * e.ll,
+		NObs:      n,
+		DF:        n - p,
+		Iter:      iter,
+	}, nil
+}
+
+// Parameter constraints not enforced by the positive definite check
+func validTheta(name string, theta []float64) bool {
+	if name == AR1 {
+		return theta[0] > 0 && math.Abs(theta[1]) < 1
+	}
+	return true
+}
+
+// An observation for a mixed model for repeated measures (MMRM): the
+// subject, the visit, the response (e.g. change from baseline), the
+// baseline value and the treatment group.
+type MMRMObs struct {
+	Subject string
+	Visit   int
+	Y       float64
+	Base    float64
+	Group   string
+}
+
+// The LS means and differences at a visit
+type MMRMVisit struct {
+	Visit  int
+	LSMean []LSMean
+	Diff   []LSDiff
+}
+
+// The results of an MMRM fit
+type MMRM struct {
+	Fit      *MixedFit
+	NSubj    int
+	Visits   []MMRMVisit
+	Attempts []string // Errors of the structures that failed
+}
+
+// Fit an MMRM with treatment-by-visit and baseline-by-visit effects, using
+// the first of the covariance structures given that converges, e.g.
+// UN then AR(1) then CS.
+// The fixed effects are coded per visit: an intercept, the groups other
+// than the reference and the baseline slope. LS means are estimated at
+// the mean baseline over all observations. Each group is compared with the
+// reference group at each visit with residual degrees of freedom;
+// confidence limits are 100(1-alpha)%.
+func FitMMRM(obs []MMRMObs, ref string, structures []string, alpha float64) (*MMRM, error) {
+	var groups []string
+	vset := make(map[int]bool)
+	var baseMean float64
+	for _, o := range obs {
+		groups = append(groups, o.Group)
+		vset[o.Visit] = true
+		baseMean += o.Base
+	}
+	if len(obs) == 0 {
+		return nil, ErrTooFewObs
+	}
+	baseMean /= float64(len(obs))
+	gl := levels(groups, ref)
+	var visits []int
+	for v := range vset {
+		visits = append(visits, v)
+	}
+	sort.Ints(visits)
+	vidx := make(map[int]int)
+	for i, v := range visits {
+		vidx[v] = i
+	}
+
+	// Design row: for each visit the intercept, group effects and baseline slope
+	width := len(gl) + 1
+	p := len(visits) * width
+	row := func(visit int, g string, base float64) []float64 {
+		x := make([]float64, p)
+		o := vidx[visit] * width
+		x[o] = 1
+		for i, v := range gl[1:] {
+			if v == g {
+				x[o+1+i] = 1
+			}
+		}
+		x[o+width-1] = base
+		return x
+	}
+
+	// Subjects in order of first appearance
+	bySubj := make(map[string]*MixedSubject)
+	var order []string
+	for _, o := range obs {
+		s, ok := bySubj[o.Subject]
+		if !ok {
+			s = &MixedSubject{}
+			bySubj[o.Subject] = s
+			order = append(order, o.Subject)
+		}
+		s.Y = append(s.Y, o.Y)
+		s.Visit = append(s.Visit, vidx[o.Visit])
+		s.X = append(s.X, row(o.Visit, o.Group, o.Base))
+	}
+	var subj []MixedSubject
+	for _, k := range order {
+		subj = append(subj, *bySubj[k])
+	}
+
+	m := &MMRM{NSubj: len(subj)}
+	for _, name := range structures {
+		fit, err := FitMixed(subj, len(visits), name)
+		if err != nil {
+			m.Attempts = append(m.Attempts, err.Error())
+			continue
+		}
+		m.Fit = fit
+		break
+	}
+	if m.Fit == nil {
+		return m, ErrNoConverge
+	}
+
+	tq := TQuantile(1-alpha/2, float64(m.Fit.DF))
+	est := func(l []float64) (float64, float64) {
+		var e float64
+		for i := range l {
+			e += l[i] * m.Fit.Beta[i]
+		}
+		return e, math.Sqrt(QuadForm(l, m.Fit.CovBeta, l))
+	}
+	n := make(map[int]map[string]int)
+	for _, o := range obs {
+		if n[o.Visit] == nil {
+			n[o.Visit] = make(map[string]int)
+		}
+		n[o.Visit][o.Group]++
+	}
+	for _, v := range visits {
+		mv := MMRMVisit{Visit: v}
+		for _, g := range gl {
+			e, se := est(row(v, g, baseMean))
+			mv.LSMean = append(mv.LSMean, LSMean{g, n[v][g], e, se, e - tq*se, e + tq*se})
+		}
+		lr := row(v, gl[0], baseMean)
+		for _, g := range gl[1:] {
+			lg := row(v, g, baseMean)
+			l := make([]float64, p)
+			for i := range l {
+				l[i] = lg[i] - lr[i]
+			}
+			e, se := est(l)
+			t := e / se
+			mv.Diff = append(mv.Diff, LSDiff{g, gl[0], e, se, e - tq*se, e + tq*se, t, TP(t, float64(m.Fit.DF))})
+		}
+		m.Visits = append(m.Visits, mv)
+	}
+	return m, nil
+}
@@ -0,0 +1,306 @@
+package CPStats
+
+import (
+	"math"
+	"testing"
+)
+
+// The dental growth data of Potthoff and Roy (1964): the distance (mm) from
+// the pituitary to the pterygomaxillary fissure at ages 8, 10, 12 and 14 of
+// 16 boys and 11 girls (Orthodont in R package nlme).
+var (
+	dentalAges = []float64{8, 10, 12, 14}
+	dentalBoys = [][]float64{
+		{26, 25, 29, 31}, {21.5, 22.5, 23, 26.5}, {23, 22.5, 24, 27.5}, {25.5, 27.5, 26.5, 27},
+		{20, 23.5, 22.5, 26}, {24.5, 25.5, 27, 28.5}, {22, 22, 24.5, 26.5}, {24, 21.5, 24.5, 25.5},
+		{23, 20.5, 31, 26}, {27.5, 28, 31, 31.5}, {23, 23, 23.5, 25}, {21.5, 23.5, 24, 28},
+		{17, 24.5, 26, 29.5}, {22.5, 25.5, 25.5, 26}, {23, 24.5, 26, 30}, {22, 21.5, 23.5, 25},
+	}
+	dentalGirls = [][]float64{
+		{21, 20, 21.5, 23}, {21, 21.5, 24, 25.5}, {20.5, 24, 24.5, 26}, {23.5, 24.5, 25, 26.5},
+		{21.5, 23, 22.5, 23.5}, {20, 21, 21, 22.5}, {21.5, 22.5, 23, 25}, {23, 23, 23.5, 24},
+		{20, 21, 22, 21.5}, {16.5, 19, 19, 19.5}, {24.5, 25, 28, 28},
+	}
+)
+
+// Published values are given to 4 decimals; the closed forms and the
+// dense evaluations are exact, up to the convergence of the fit
+const (
+	pubTol   = 5e-4
+	exactTol = 1e-5
+)
+
+// The dental data at the visits given (indexes of dentalAges) as mixed
+// model subjects, boys then girls, with a design row for each response
+func dental(visits []int, design func(girl bool, visit int) []float64) []MixedSubject {
+	var subj []MixedSubject
+	add := func(data [][]float64, girl bool) {
+		for _, d := range data {
+			s := MixedSubject{}
+			for i, v := range visits {
+				s.Y = append(s.Y, d[v])
+				s.Visit = append(s.Visit, i)
+				s.X = append(s.X, design(girl, v))
+			}
+			subj = append(subj, s)
+		}
+	}
+	add(dentalBoys, false)
+	add(dentalGirls, true)
+	return subj
+}
+
+// Designs: a mean for each sex and age, a common age slope with a sex
+// effect, and a line for each sex
+func cellMeans(visits int) func(bool, int) []float64 {
+	return func(girl bool, v int) []float64 {
+		x := make([]float64, 2*visits)
+		if girl {
+			x[visits+v] = 1
+		} else {
+			x[v] = 1
+		}
+		return x
+	}
+}
+
+func additive(girl bool, v int) []float64 {
+	return []float64{1, dentalAges[v], b2f(girl)}
+}
+
+func lines(girl bool, v int) []float64 {
+	return []float64{1, b2f(girl), dentalAges[v], b2f(girl) * dentalAges[v]}
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// The -2 residual log likelihood of a covariance matrix over the visits,
+// evaluated from the full covariance matrix of all the responses rather
+// than subject by subject, with the GLS estimates of the fixed effects
+func denseNegTwoLL(t *testing.T, subj []MixedSubject, sigma Matrix) float64 {
+	var y []float64
+	var x [][]float64
+	var vis []int
+	for _, s := range subj {
+		y = append(y, s.Y...)
+		x = append(x, s.X...)
+		vis = append(vis, s.Visit...)
+	}
+	n, p := len(y), len(x[0])
+	v := NewMatrix(n, n)
+	start := 0
+	for _, s := range subj {
+		for a := range s.Y {
+			for b := range s.Y {
+				v[start+a][start+b] = sigma[vis[start+a]][vis[start+b]]
+			}
+		}
+		start += len(s.Y)
+	}
+	vi, ldv, err := InverseSPD(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xvx := NewMatrix(p, p)
+	xvy := make([]float64, p)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			for a := 0; a < p; a++ {
+				xvy[a] += x[i][a] * vi[i][j] * y[j]
+				for b := 0; b < p; b++ {
+					xvx[a][b] += x[i][a] * vi[i][j] * x[j][b]
+				}
+			}
+		}
+	}
+	xvxi, ldx, err := InverseSPD(xvx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beta := xvxi.MulVec(xvy)
+	r := make([]float64, n)
+	for i := range r {
+		r[i] = y[i]
+		for a := range beta {
+			r[i] -= x[i][a] * beta[a]
+		}
+	}
+	return float64(n-p)*math.Log(2*math.Pi) + ldv + ldx + QuadForm(r, vi, r)
+}
+
+func ar1Sigma(v, rho float64, t int) Matrix {
+	s := NewMatrix(t, t)
+	for i := range s {
+		for j := range s[i] {
+			s[i][j] = v * math.Pow(rho, math.Abs(float64(i-j)))
+		}
+	}
+	return s
+}
+
+func fit(t *testing.T, subj []MixedSubject, visits int, name string) *MixedFit {
+	f, err := FitMixed(subj, visits, name)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return f
+}
+
+func near(t *testing.T, what string, got, want, tol float64) {
+	if math.Abs(got-want) > tol {
+		t.Errorf("%s = %.6f, want %.6f (tolerance %g)", what, got, want, tol)
+	}
+}
+
+// UN with a mean for each sex and age has closed form REML estimates: the
+// covariance is the pooled within-sex covariance matrix of the ages and the
+// means are the observed means, so that
+// -2RLL = (n-p)log(2pi) + (N-2)log|S| + t(log 16 + log 11) + t(N-2)
+// over N subjects, n responses and p = 2t fixed effects.
+func TestMixedUNSaturated(t *testing.T) {
+	all := []int{0, 1, 2, 3}
+	f := fit(t, dental(all, cellMeans(4)), 4, UN)
+
+	s := NewMatrix(4, 4)
+	var means []float64
+	for _, g := range [][][]float64{dentalBoys, dentalGirls} {
+		m := make([]float64, 4)
+		for _, d := range g {
+			for a := range m {
+				m[a] += d[a] / float64(len(g))
+			}
+		}
+		means = append(means, m...)
+		for _, d := range g {
+			for a := range m {
+				for b := range m {
+					s[a][b] += (d[a] - m[a]) * (d[b] - m[b]) / 25
+				}
+			}
+		}
+	}
+	for a := range s {
+		for b := range s {
+			near(t, "UN sigma", f.Sigma[a][b], s[a][b], exactTol)
+		}
+	}
+	for i := range means {
+		near(t, "UN LS mean", f.Beta[i], means[i], exactTol)
+	}
+	// 	The means of boys and girls at ages 8 and 14, to 4 decimals
+	near(t, "boys age 8", f.Beta[0], 22.875, pubTol)
+	near(t, "boys age 14", f.Beta[3], 27.4688, pubTol)
+	near(t, "girls age 8", f.Beta[4], 21.1818, pubTol)
+	near(t, "girls age 14", f.Beta[7], 24.0909, pubTol)
+
+	_, lds, err := InverseSPD(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 100*math.Log(2*math.Pi) + 25*lds + 4*(math.Log(16)+math.Log(11)) + 100
+	near(t, "UN -2RLL", f.NegTwoLL, want, exactTol)
+}
+
+// UN with a line for each sex: the fixed effects published for TYPE=UN in
+// the repeated measures example of the SAS/STAT PROC MIXED documentation
+func TestMixedUNLines(t *testing.T) {
+	subj := dental([]int{0, 1, 2, 3}, lines)
+	f := fit(t, subj, 4, UN)
+	for i, want := range []float64{15.8423, 1.5831, 0.8268, -0.3504} {
+		near(t, "UN beta", f.Beta[i], want, pubTol)
+	}
+	near(t, "UN -2RLL dense", f.NegTwoLL, denseNegTwoLL(t, subj, f.Sigma), exactTol)
+}
+
+// CS with a common age slope and a sex effect is the random intercept model
+// lme(distance ~ age + Sex, random = ~1 | Subject) of Pinheiro and Bates
+// (2000), with REML log likelihood -218.7563, standard deviations 1.807425
+// (intercept) and 1.431592 (residual) and fixed effects 17.70671, 0.6601852
+// and -2.321023.
+func TestMixedCS(t *testing.T) {
+	subj := dental([]int{0, 1, 2, 3}, additive)
+	f := fit(t, subj, 4, CS)
+	near(t, "CS -2RLL", f.NegTwoLL, 2*218.7563, pubTol)
+	near(t, "CS between subject", f.Theta[0], 1.807425*1.807425, pubTol)
+	near(t, "CS residual", f.Theta[1], 1.431592*1.431592, pubTol)
+	for i, want := range []float64{17.70671, 0.6601852, -2.321023} {
+		near(t, "CS beta", f.Beta[i], want, pubTol)
+	}
+}
+
+// AR(1) is checked with no published fit: at the estimates the -2RLL is
+// the dense evaluation and no smaller on either side of each parameter, and
+// over two visits the AR(1) fit is the CS fit, with variance the sum of the
+// CS parameters and correlation the between subject share of it.
+func TestMixedAR1(t *testing.T) {
+	subj := dental([]int{0, 1, 2, 3}, lines)
+	f := fit(t, subj, 4, AR1)
+	v, rho := f.Theta[0], f.Theta[1]
+	near(t, "AR(1) -2RLL dense", f.NegTwoLL, denseNegTwoLL(t, subj, ar1Sigma(v, rho, 4)), exactTol)
+	for _, h := range []float64{-1e-3, 1e-3} {
+		if ll := denseNegTwoLL(t, subj, ar1Sigma(v+h, rho, 4)); ll < f.NegTwoLL-exactTol {
+			t.Errorf("AR(1) -2RLL %.6f at variance %+g, %.6f at the estimates", ll, h, f.NegTwoLL)
+		}
+		if ll := denseNegTwoLL(t, subj, ar1Sigma(v, rho+h, 4)); ll < f.NegTwoLL-exactTol {
+			t.Errorf("AR(1) -2RLL %.6f at correlation %+g, %.6f at the estimates", ll, h, f.NegTwoLL)
+		}
+	}
+
+	two := dental([]int{0, 1}, cellMeans(2))
+	a := fit(t, two, 2, AR1)
+	c := fit(t, two, 2, CS)
+	near(t, "AR(1) -2RLL two visits", a.NegTwoLL, c.NegTwoLL, exactTol)
+	near(t, "AR(1) variance two visits", a.Theta[0], c.Theta[0]+c.Theta[1], exactTol)
+	near(t, "AR(1) correlation two visits", a.Theta[1], c.Theta[0]/(c.Theta[0]+c.Theta[1]), exactTol)
+}
+
+// With complete data, UN and the same regressors at each visit, the GLS
+// estimates are those of a separate regression at each visit, so the MMRM
+// LS means and differences are those of the ANCOVA at each visit, here of
+// the distances at ages 10, 12 and 14 with the distance at age 8 as
+// baseline.
+func TestFitMMRM(t *testing.T) {
+	var obs []MMRMObs
+	anc := make(map[int][]AncovaObs)
+	add := func(data [][]float64, group string) {
+		for i, d := range data {
+			subject := group + string(rune('A'+i))
+			for v := 1; v < 4; v++ {
+				age := int(dentalAges[v])
+				obs = append(obs, MMRMObs{Subject: subject, Visit: age, Y: d[v], Base: d[0], Group: group})
+				anc[age] = append(anc[age], AncovaObs{Y: d[v], Group: group, Cov: d[0]})
+			}
+		}
+	}
+	add(dentalBoys, "Boys")
+	add(dentalGirls, "Girls")
+
+	m, err := FitMMRM(obs, "Boys", []string{UN}, 0.05)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NSubj != 27 || len(m.Visits) != 3 {
+		t.Fatalf("MMRM of %d subjects and %d visits, want 27 and 3", m.NSubj, len(m.Visits))
+	}
+	for _, mv := range m.Visits {
+		a, err := FitAncova(anc[mv.Visit], "Boys", false, 0.05)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, l := range mv.LSMean {
+			if l.Group != a.LSMean[i].Group || l.N != a.LSMean[i].N {
+				t.Errorf("age %d: LS mean of %s (n %d), want %s (n %d)", mv.Visit, l.Group, l.N,
+					a.LSMean[i].Group, a.LSMean[i].N)
+			}
+			near(t, "LS mean", l.Est, a.LSMean[i].Est, exactTol)
+			near(t, "LS mean SE", l.SE, a.LSMean[i].SE, exactTol)
+		}
+		near(t, "LS mean difference", mv.Diff[0].Est, a.Diff[0].Est, exactTol)
+		near(t, "LS mean difference SE", mv.Diff[0].SE, a.Diff[0].SE, exactTol)
+	}
+}
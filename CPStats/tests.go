@@ -0,0 +1,352 @@
+package CPStats
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Hypothesis tests comparing two groups
+const (
+	TTest    = "TTEST"    // Two-sample t-test, pooled variance
+	Wilcoxon = "WILCOXON" // Wilcoxon rank sum test, normal approximation
+	ChiSq    = "CHISQ"    // Pearson chi-square test
+	Fisher   = "FISHER"   // Fisher's exact test
+)
+
+// Approaches to the choice of a test
+const (
+	Parametric    = "PARAM"  // t-test; chi-square unless expected counts are small
+	NonParametric = "NONPAR" // Wilcoxon; Fisher's exact test
+)
+
+// The result of a test. DF is zero for tests without degrees of freedom.
+type TestResult struct {
+	Test string
+	Stat float64
+	DF   float64
+	P    float64
+}
+
+// Errors from the tests
+var (
+	ErrTest      = errors.New("unknown test")
+	ErrTestData  = errors.New("insufficient data for test")
+	ErrTableSize = errors.New("Fisher's exact test needs a table with 2 rows or 2 columns")
+)
+
+// The test for a continuous variable under an approach
+func ContTest(approach string) string {
+	if approach == NonParametric {
+		return Wilcoxon
+	}
+	return TTest
+}
+
+// The test for a contingency table under an approach. The parametric
+// approach uses the chi-square test unless more than 20% of the cells have
+// an expected count below 5, when Fisher's exact test is used.
+func CatTest(approach string, table [][]int) string {
+	if approach == NonParametric {
+		return Fisher
+	}
+	exp, _, _ := expected(table)
+	var small, cells int
+	for i := range exp {
+		for j := range exp[i] {
+			cells++
+			if exp[i][j] < 5 {
+				small++
+			}
+		}
+	}
+	if cells > 0 && float64(small)/float64(cells) > 0.2 {
+		return Fisher
+	}
+	return ChiSq
+}
+
+// Run a test comparing a continuous variable in two groups
+func RunCont(test string, x, y []float64) (TestResult, error) {
+	switch test {
+	case TTest:
+		return TwoSampleT(x, y)
+	case Wilcoxon:
+		return RankSum(x, y)
+	}
+	return TestResult{}, ErrTest
+}
+
+// Run a test of association in a contingency table
+func RunCat(test string, table [][]int) (TestResult, error) {
+	switch test {
+	case ChiSq:
+		return ChiSquare(table)
+	case Fisher:
+		return FisherExact(table)
+	}
+	return TestResult{}, ErrTest
+}
+
+// Mean and sample variance
+func meanVar(x []float64) (float64, float64) {
+	var m, v float64
+	for _, a := range x {
+		m += a
+	}
+	m /= float64(len(x))
+	for _, a := range x {
+		v += (a - m) * (a - m)
+	}
+	return m, v / float64(len(x)-1)
+}
+
+// Two-sample t-test assuming equal variances
+func TwoSampleT(x, y []float64) (TestResult, error) {
+	n1, n2 := float64(len(x)), float64(len(y))
+	if n1 < 2 || n2 < 2 {
+		return TestResult{}, ErrTestData
+	}
+	m1, v1 := meanVar(x)
+	m2, v2 := meanVar(y)
+	df := n1 + n2 - 2
+	sp := ((n1-1)*v1 + (n2-1)*v2) / df
+	if sp == 0 {
+		return TestResult{}, ErrTestData
+	}
+	t := (m1 - m2) / math.Sqrt(sp*(1/n1+1/n2))
+	return TestResult{TTest, t, df, TP(t, df)}, nil
+}
+
+// Wilcoxon rank sum test by the normal approximation with a continuity
+// correction of 0.5 and the variance adjusted for ties, as in SAS NPAR1WAY.
+// The statistic is the Z value of the rank sum of x.
+func RankSum(x, y []float64) (TestResult, error) {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return TestResult{}, ErrTestData
+	}
+	type obs struct {
+		v float64
+		g int
+	}
+	var all []obs
+	for _, v := range x {
+		all = append(all, obs{v, 0})
+	}
+	for _, v := range y {
+		all = append(all, obs{v, 1})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Mid-ranks of tied values
+	n := float64(len(all))
+	var w, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		t := float64(j - i)
+		ties += t*t*t - t
+		for k := i; k < j; k++ {
+			if all[k].g == 0 {
+				w += rank
+			}
+		}
+		i = j
+	}
+	e := float64(n1) * (n + 1) / 2
+	v := float64(n1) * float64(n2) / 12 * ((n + 1) - ties/(n*(n-1)))
+	if v <= 0 {
+		return TestResult{}, ErrTestData
+	}
+	d := w - e
+	switch {
+	case d > 0.5:
+		d -= 0.5
+	case d < -0.5:
+		d += 0.5
+	default:
+		d = 0
+	}
+	z := d / math.Sqrt(v)
+	return TestResult{Wilcoxon, z, 0, 2 * (1 - NormalCDF(math.Abs(z)))}, nil
+}
+
+// Expected counts of a table under independence, with the row and column totals.
+// Rows and columns with a zero total are dropped.
+func expected(table [][]int) ([][]float64, []int, []int) {
+	var rows, cols []int
+	if len(table) == 0 {
+		return nil, nil, nil
+	}
+	ct := make([]int, len(table[0]))
+	for _, r := range table {
+		for j, c := range r {
+			ct[j] += c
+		}
+	}
+	var n int
+	for _, r := range table {
+		var rt int
+		for _, c := range r {
+			rt += c
+		}
+		if rt > 0 {
+			rows = append(rows, rt)
+			n += rt
+		}
+	}
+	for _, c := range ct {
+		if c > 0 {
+			cols = append(cols, c)
+		}
+	}
+	exp := make([][]float64, len(rows))
+	for i, r := range rows {
+		exp[i] = make([]float64, len(cols))
+		for j, c := range cols {
+			exp[i][j] = float64(r) * float64(c) / float64(n)
+		}
+	}
+	return exp, rows, cols
+}
+
+// The table without rows and columns with a zero total
+func compact(table [][]int) [][]int {
+	if len(table) == 0 {
+		return nil
+	}
+	ct := make([]int, len(table[0]))
+	for _, r := range table {
+		for j, c := range r {
+			ct[j] += c
+		}
+	}
+	var out [][]int
+	for _, r := range table {
+		var row []int
+		var rt int
+		for j, c := range r {
+			if ct[j] > 0 {
+				row = append(row, c)
+				rt += c
+			}
+		}
+		if rt > 0 {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// Pearson chi-square test of independence
+func ChiSquare(table [][]int) (TestResult, error) {
+	t := compact(table)
+	exp, rows, cols := expected(t)
+	if len(rows) < 2 || len(cols) < 2 {
+		return TestResult{}, ErrTestData
+	}
+	var x float64
+	for i := range t {
+		for j := range t[i] {
+			d := float64(t[i][j]) - exp[i][j]
+			x += d * d / exp[i][j]
+		}
+	}
+	df := float64((len(rows) - 1) * (len(cols) - 1))
+	return TestResult{ChiSq, x, df, ChiSqP(x, df)}, nil
+}
+
+// Log of the binomial coefficient
+func lchoose(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
+
+// Fisher's exact test of an r x 2 (or 2 x c) table, by enumerating all
+// tables with the same margins. The p-value is the sum of the probabilities
+// of the tables no more likely than the observed one.
+// The statistic is the probability of the observed table.
+func FisherExact(table [][]int) (TestResult, error) {
+	t := compact(table)
+	if len(t) < 2 || len(t[0]) < 2 {
+		return TestResult{}, ErrTestData
+	}
+	if len(t[0]) != 2 {
+		if len(t) != 2 {
+			return TestResult{}, ErrTableSize
+		}
+		// Transpose a 2 x c table
+		tt := make([][]int, len(t[0]))
+		for j := range tt {
+			tt[j] = []int{t[0][j], t[1][j]}
+		}
+		t = tt
+	}
+
+	// Row totals and the first column total are fixed
+	rows := make([]int, len(t))
+	var c1, n int
+	for i, r := range t {
+		rows[i] = r[0] + r[1]
+		c1 += r[0]
+		n += rows[i]
+	}
+	lnorm := lchoose(n, c1)
+	lprob := func(x []int) float64 {
+		var l float64
+		for i, r := range rows {
+			l += lchoose(r, x[i])
+		}
+		return l - lnorm
+	}
+	obs := make([]int, len(t))
+	for i, r := range t {
+		obs[i] = r[0]
+	}
+	pobs := lprob(obs)
+
+	// Remaining capacity of the rows after i, to prune the enumeration
+	rest := make([]int, len(rows)+1)
+	for i := len(rows) - 1; i >= 0; i-- {
+		rest[i] = rest[i+1] + rows[i]
+	}
+	var p float64
+	x := make([]int, len(rows))
+	var enum func(i, left int)
+	enum = func(i, left int) {
+		if i == len(rows)-1 {
+			if left > rows[i] {
+				return
+			}
+			x[i] = left
+			if l := lprob(x); l <= pobs+1e-7 {
+				p += math.Exp(l)
+			}
+			return
+		}
+		for v := 0; v <= rows[i] && v <= left; v++ {
+			if left-v > rest[i+1] {
+				continue
+			}
+			x[i] = v
+			enum(i+1, left-v)
+		}
+	}
+	enum(0, c1)
+	return TestResult{Fisher, math.Exp(pobs), 0, math.Min(1, p)}, nil
+}
+
+// Format a p-value for display, with small values shown as <.0001
+func FormatP(p float64) string {
+	if p < 0.0001 {
+		return "<.0001"
+	}
+	return fmt.Sprintf("%.4f", p)
+}
@@ -36,6 +36,7 @@ import (
 	"time"
 
 	"github.com/phil0lucas/GoForCP/CPUtils"
+	"github.com/phil0lucas/GoForCP/Missing"
 )
 
 // The data will be created as a slice of pointers to objects of the struct
@@ -81,12 +82,33 @@ const (
 // To generate a random age within a range
 // min age = 20, max age = 80
 func getAge() *int {
-	if CPUtils.FlagMiss(0) == false {
-		rand.Seed(time.Now().UTC().UnixNano())
-		r := rand.Intn(59) + 20
-		return &r
-	} else {
-		return nil
+	rand.Seed(time.Now().UTC().UnixNano())
+	r := rand.Intn(59) + 20
+	return &r
+}
+
+// Apply the missing-data rules to AGE, SEX and RACE.
+// The covariates available to MAR rules are those recorded for the subject
+// before any values are removed.
+func applyMiss(m *Missing.Engine, d *Dmrec) {
+	cov := map[string]string{
+		"SITEID":  d.Siteid,
+		"COUNTRY": d.Country,
+		"INVID":   d.Invid,
+		"AGE":     CPUtils.IntP2Str(d.Age),
+		"SEX":     CPUtils.StrP2Str(d.Sex),
+		"RACE":    CPUtils.StrP2Str(d.Race),
+		"ARM":     CPUtils.StrP2Str(d.Arm),
+	}
+	if m.Flag(domain, "AGE", cov, cov["AGE"]) {
+		d.Age = nil
+		d.Brthdtc = nil
+	}
+	if m.Flag(domain, "SEX", cov, cov["SEX"]) {
+		d.Sex = nil
+	}
+	if m.Flag(domain, "RACE", cov, cov["RACE"]) {
+		d.Race = nil
 	}
 }
 
@@ -109,8 +131,11 @@ func getBday(dmdtc time.Time, age *int) *time.Time {
 }
 
 // Generate the DM data for each subject and write to a slice of pointers
-// before writing to an output CSV
-func WriteDM(infile, outfile *string) {
+// before writing to an output CSV.
+// Missing values are assigned according to the rules in the missing-data
+// specification file (see package Missing); a blank name keeps the default 5% MCAR.
+func WriteDM(infile, outfile, missfile *string) {
+	miss := Missing.ReadSpec(missfile)
 
 	// open the file and pass it to a Scanner object
 	file, err := os.Open(*infile)
@@ -148,12 +173,12 @@ func WriteDM(infile, outfile *string) {
 		_, country := CPUtils.RandItem(ctrymap)
 		age := getAge()
 		brthdtc := getBday(dmdtc, age)
-		sex := CPUtils.RandItemP(sexmp)
-		race := CPUtils.RandItemP(racemp)
+		_, sex := CPUtils.RandItem(sexmp)
+		_, race := CPUtils.RandItem(racemp)
 		armcd := CPUtils.Str2IntP(strings.Split(str, ",")[9])
 		arm := CPUtils.Str2StrP(strings.Split(str, ",")[10])
 		//
-		dm = append(dm, &Dmrec{
+		d := &Dmrec{
 			Studyid: studyid,
 			Domain:  domain,
 			Usubjid: usubjid,
@@ -168,14 +193,21 @@ func WriteDM(infile, outfile *string) {
 			Ageu:    ageu,
 			Age:     age,
 			Brthdtc: brthdtc,
-			Sex:     sex,
-			Race:    race,
+			Sex:     &sex,
+			Race:    &race,
 			Armcd:   armcd,
 			Arm:     arm,
 			Dmdy:    dmdy,
-		})
+		}
+		applyMiss(miss, d)
+		dm = append(dm, d)
 	}
 
+	WriteCSV(dm, outfile)
+}
+
+// Write a slice of DM records to a CSV file in the layout read by ReadDM.
+func WriteCSV(dm []*Dmrec, outfile *string) {
 	// Output file writing section
 	fo, err := os.Create(*outfile)
 	if err != nil {
@@ -343,3 +375,61 @@ func SubsetByArm(dm []*Dmrec, value string) []*Dmrec {
 	}
 	return subdm
 }
+
+// The value of a variable by name, in character form as in the CSV.
+// Blank for a missing value or an unknown name.
+func (d *Dmrec) Get(name string) string {
+	switch strings.ToUpper(name) {
+	case "STUDYID":
+		return d.Studyid
+	case "DOMAIN":
+		return d.Domain
+	case "USUBJID":
+		return d.Usubjid
+	case "SUBJID":
+		return d.Subjid
+	case "SITEID":
+		return d.Siteid
+	case "RFSTDTC":
+		return CPUtils.DateP2Str(d.Rfstdtc)
+	case "RFENDTC":
+		return CPUtils.DateP2Str(d.Rfendtc)
+	case "DMDTC":
+		return d.Dmdtc.Format("2006-01-02")
+	case "INVID":
+		return d.Invid
+	case "INVNAME":
+		return d.Invname
+	case "COUNTRY":
+		return d.Country
+	case "AGE":
+		return CPUtils.IntP2Str(d.Age)
+	case "AGEU":
+		return d.Ageu
+	case "BRTHDTC":
+		return CPUtils.DateP2Str(d.Brthdtc)
+	case "SEX":
+		return CPUtils.StrP2Str(d.Sex)
+	case "RACE":
+		return CPUtils.StrP2Str(d.Race)
+	case "ARMCD":
+		return CPUtils.IntP2Str(d.Armcd)
+	case "ARM":
+		return CPUtils.StrP2Str(d.Arm)
+	case "DMDY":
+		return strconv.Itoa(d.Dmdy)
+	}
+	return ""
+}
+
+// Subset the slice of pointers to Dmrec to the subjects in a set,
+// typically a population taken from ADSL.
+func Subset(dm []*Dmrec, set map[string]bool) []*Dmrec {
+	var subdm []*Dmrec
+	for _, v := range dm {
+		if set[v.Usubjid] {
+			subdm = append(subdm, v)
+		}
+	}
+	return subdm
+}
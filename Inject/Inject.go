@@ -0,0 +1,422 @@
+// Error injection for QC testing.
+//
+// The generators produce clean data. This package takes the records of a
+// generated domain and inserts controlled anomalies so that validation
+// checks can be run against them and scored for recall.
+// Every anomaly inserted is described by an Entry and the full set is
+// written to a manifest CSV:
+// - ID        Num   Running number of the anomaly
+// - DOMAIN    Char  Domain abbreviation
+// - USUBJID   Char  Unique Subject Identifier of the affected record
+// - SEQ       Num   VSSEQ of the affected record (blank for DM)
+// - VISITNUM  Num   Visit number of the affected record (blank for DM)
+// - VARIABLE  Char  Variable changed
+// - TYPE      Char  Type of anomaly (see the constants below)
+// - ORIGINAL  Char  Value before injection
+// - INJECTED  Char  Value after injection
+//
+// Anomaly types:
+// - IMPLAUSIBLE  A physiologically implausible vital sign e.g. SBP of 400
+// - SWAPPED      SBP and DBP exchanged for a subject's reading
+// - DUPLICATE    A record repeated with the same key
+// - DATEORDER    A date earlier than the one it should follow
+// - UNITS        A blood pressure recorded in kPa but labelled mmHg, or recorded
+//                in mmHg but labelled kPa where kPa is the local unit
+// - INVALIDCODE  A value outside its controlled terminology
+package Inject
+
+import (
+	"bufio"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phil0lucas/GoForCP/CPUtils"
+	"github.com/phil0lucas/GoForCP/DM"
+	units "github.com/phil0lucas/GoForCP/Units"
+	"github.com/phil0lucas/GoForCP/VS"
+)
+
+// Types of anomaly
+const (
+	Implausible = "IMPLAUSIBLE"
+	Swapped     = "SWAPPED"
+	Duplicate   = "DUPLICATE"
+	DateOrder   = "DATEORDER"
+	Units       = "UNITS"
+	InvalidCode = "INVALIDCODE"
+)
+
+// All anomaly types, in the order they are applied
+var AllTypes = []string{Implausible, Swapped, Duplicate, DateOrder, Units, InvalidCode}
+
+// One line of the manifest
+type Entry struct {
+	Id       int
+	Domain   string
+	Usubjid  string
+	Seq      *int
+	Visitnum *int
+	Variable string
+	Type     string
+	Original string
+	Injected string
+}
+
+// Implausible values per VS test code
+var implausible = map[string][]float64{
+	"SBP":    {400, 0, 15},
+	"DBP":    {250, 0, 5},
+	"HR":     {350, 0, 8},
+	"HEIGHT": {20, 290},
+	"WEIGHT": {0, 700},
+	"TEMP":   {25, 46},
+	"RESP":   {0, 95},
+}
+
+// Codes outside the controlled terminology
+var badTestcd = []string{"SYSBP", "BP", "XXX"}
+var badSex = []string{"U", "X", "Male"}
+var badRace = []string{"Other", "Hispanic", "9"}
+var badCountry = []string{"UK", "ZZZ", "DEU1"}
+
+// mmHg per kPa, for the unit mix-up
+const mmHgPerkPa = 7.50062
+
+// The injector holds the random source and the manifest being built
+type Injector struct {
+	rnd      *rand.Rand
+	rate     float64
+	types    []string
+	Manifest []Entry
+}
+
+// Create an injector. rate is the proportion of eligible records affected
+// by each anomaly type (at least one record is always affected) and types
+// is the list of anomaly types to apply; nil means all of them.
+func New(rate float64, types []string) *Injector {
+	if types == nil {
+		types = AllTypes
+	}
+	return &Injector{
+		rnd:   rand.New(rand.NewSource(time.Now().UTC().UnixNano())),
+		rate:  rate,
+		types: types,
+	}
+}
+
+// Determine how many of n eligible records to change
+func (in *Injector) count(n int) int {
+	if n == 0 {
+		return 0
+	}
+	c := int(in.rate*float64(n) + 0.5)
+	if c < 1 {
+		c = 1
+	}
+	if c > n {
+		c = n
+	}
+	return c
+}
+
+// Randomly choose c distinct indices from the eligible ones
+func (in *Injector) pick(eligible []int, c int) []int {
+	p := in.rnd.Perm(len(eligible))
+	var out []int
+	for _, i := range p[:c] {
+		out = append(out, eligible[i])
+	}
+	sort.Ints(out)
+	return out
+}
+
+// Randomly choose one of a list of values, from the injector's random source
+func (in *Injector) choice(s []string) string {
+	return s[in.rnd.Intn(len(s))]
+}
+
+// Add an entry to the manifest
+func (in *Injector) record(e Entry) {
+	e.Id = len(in.Manifest) + 1
+	in.Manifest = append(in.Manifest, e)
+}
+
+// Manifest entry for a VS record
+func vsEntry(v *VS.Vsrec, variable, typ, orig, inj string) Entry {
+	seq := v.Vsseq
+	visit := v.Visitnum
+	return Entry{
+		Domain:   v.Domain,
+		Usubjid:  v.Usubjid,
+		Seq:      &seq,
+		Visitnum: &visit,
+		Variable: variable,
+		Type:     typ,
+		Original: orig,
+		Injected: inj,
+	}
+}
+
+// Set the original result of a VS record, as recorded in its VSORRESU, and
+// derive the standardized result from it as the generator does, so that the
+// two stay consistent
+func setOrres(v *VS.Vsrec, orres float64) {
+	stresn := orres
+	if std, _, err := units.Standardize(v.Vstestcd, orres, CPUtils.StrP2Str(v.Vsorresu)); err == nil {
+		stresn = units.Round(std, 2)
+	}
+	v.Vsorres = &orres
+	v.Vsstresn = &stresn
+	v.Vsstresc = CPUtils.FloatP2StrP(&stresn, 2)
+}
+
+// Set the result of a VS record to a value in the standard unit, recorded
+// in the record's VSORRESU (e.g. lb or kPa for some countries), so that the
+// only anomaly is the one injected
+func setResult(v *VS.Vsrec, value float64) {
+	orres := value
+	if o, err := units.Convert(value, units.Standard(v.Vstestcd), CPUtils.StrP2Str(v.Vsorresu)); err == nil {
+		orres = units.Round(o, 1)
+	}
+	setOrres(v, orres)
+}
+
+// Inject anomalies into VS records. The input slice is not changed; the
+// returned slice holds copies of the records with the anomalies applied.
+func (in *Injector) VS(vs []*VS.Vsrec) []*VS.Vsrec {
+	out := make([]*VS.Vsrec, len(vs))
+	for i, v := range vs {
+		c := *v
+		out[i] = &c
+	}
+
+	// Records with a result, by test code
+	var withRes []int
+	for i, v := range out {
+		if v.Vsstresn != nil && implausible[v.Vstestcd] != nil {
+			withRes = append(withRes, i)
+		}
+	}
+
+	for _, t := range in.types {
+		switch t {
+		case Implausible:
+			for _, i := range in.pick(withRes, in.count(len(withRes))) {
+				v := out[i]
+				vals := implausible[v.Vstestcd]
+				orig := CPUtils.FloatP2Str(v.Vsstresn, 1)
+				setResult(v, vals[in.rnd.Intn(len(vals))])
+				in.record(vsEntry(v, "VSSTRESN", t, orig, CPUtils.FloatP2Str(v.Vsstresn, 1)))
+			}
+
+		case Swapped:
+			// Pair up SBP and DBP records for the same subject, visit and reading
+			type kv struct {
+				usubjid  string
+				visitnum int
+				vspos    string
+				vstptnum int
+			}
+			sbp := make(map[kv]int)
+			for _, i := range withRes {
+				if out[i].Vstestcd == "SBP" {
+					sbp[kv{out[i].Usubjid, out[i].Visitnum, out[i].Vspos, out[i].Vstptnum}] = i
+				}
+			}
+			var pairs [][2]int
+			var eligible []int
+			for _, i := range withRes {
+				if out[i].Vstestcd == "DBP" {
+					if j, ok := sbp[kv{out[i].Usubjid, out[i].Visitnum, out[i].Vspos, out[i].Vstptnum}]; ok {
+						eligible = append(eligible, len(pairs))
+						pairs = append(pairs, [2]int{j, i})
+					}
+				}
+			}
+			for _, p := range in.pick(eligible, in.count(len(eligible))) {
+				s, d := out[pairs[p][0]], out[pairs[p][1]]
+				sOrig := CPUtils.FloatP2Str(s.Vsstresn, 1)
+				dOrig := CPUtils.FloatP2Str(d.Vsstresn, 1)
+				sv, dv := *s.Vsstresn, *d.Vsstresn
+				setResult(s, dv)
+				setResult(d, sv)
+				in.record(vsEntry(s, "VSSTRESN", t, sOrig, dOrig))
+				in.record(vsEntry(d, "VSSTRESN", t, dOrig, sOrig))
+			}
+
+		case Duplicate:
+			all := make([]int, len(out))
+			for i := range all {
+				all[i] = i
+			}
+			for _, i := range in.pick(all, in.count(len(all))) {
+				c := *out[i]
+				out = append(out, &c)
+				in.record(vsEntry(&c, "VSSEQ", t, "", strconv.Itoa(c.Vsseq)))
+			}
+
+		case DateOrder:
+			// Post-screening visits get a date before the previous visit
+			var eligible []int
+			for i, v := range out {
+				if v.Visitnum > 0 {
+					eligible = append(eligible, i)
+				}
+			}
+			for _, i := range in.pick(eligible, in.count(len(eligible))) {
+				v := out[i]
+				orig := v.Vsdtc.Format("2006-01-02")
+				days := 14 + in.rnd.Intn(14) + 1
+				v.Vsdtc = v.Vsdtc.AddDate(0, 0, -days)
+				v.Vsdy = v.Vsdy - days
+				in.record(vsEntry(v, "VSDTC", t, orig, v.Vsdtc.Format("2006-01-02")))
+			}
+
+		case Units:
+			// Blood pressure recorded in kPa but labelled mmHg or, where it is
+			// recorded in kPa (SWE), the reverse: recorded in mmHg but labelled kPa
+			var eligible []int
+			for _, i := range withRes {
+				if out[i].Vstestcd == "SBP" || out[i].Vstestcd == "DBP" {
+					eligible = append(eligible, i)
+				}
+			}
+			for _, i := range in.pick(eligible, in.count(len(eligible))) {
+				v := out[i]
+				orig := CPUtils.FloatP2Str(v.Vsstresn, 1)
+				if CPUtils.StrP2Str(v.Vsorresu) == "kPa" {
+					setOrres(v, units.Round(*v.Vsstresn, 1))
+				} else {
+					setResult(v, units.Round(*v.Vsstresn/mmHgPerkPa, 1))
+				}
+				in.record(vsEntry(v, "VSSTRESN", t, orig, CPUtils.FloatP2Str(v.Vsstresn, 1)))
+			}
+
+		case InvalidCode:
+			var eligible []int
+			for i, v := range out {
+				if v.Vstestcd != "" {
+					eligible = append(eligible, i)
+				}
+			}
+			for _, i := range in.pick(eligible, in.count(len(eligible))) {
+				v := out[i]
+				orig := v.Vstestcd
+				v.Vstestcd = in.choice(badTestcd)
+				in.record(vsEntry(v, "VSTESTCD", t, orig, v.Vstestcd))
+			}
+		}
+	}
+	return out
+}
+
+// Manifest entry for a DM record
+func dmEntry(d *DM.Dmrec, variable, typ, orig, inj string) Entry {
+	return Entry{
+		Domain:   d.Domain,
+		Usubjid:  d.Usubjid,
+		Variable: variable,
+		Type:     typ,
+		Original: orig,
+		Injected: inj,
+	}
+}
+
+// Inject anomalies into DM records. As for VS the input is not changed.
+// The vital-sign specific types (IMPLAUSIBLE, SWAPPED, UNITS) do not apply;
+// DATEORDER moves the birth date after the screening date and INVALIDCODE
+// sets SEX, RACE or COUNTRY outside their code lists.
+func (in *Injector) DM(dm []*DM.Dmrec) []*DM.Dmrec {
+	out := make([]*DM.Dmrec, len(dm))
+	all := make([]int, len(dm))
+	for i, d := range dm {
+		c := *d
+		out[i] = &c
+		all[i] = i
+	}
+
+	for _, t := range in.types {
+		switch t {
+		case Duplicate:
+			for _, i := range in.pick(all, in.count(len(all))) {
+				c := *out[i]
+				out = append(out, &c)
+				in.record(dmEntry(&c, "USUBJID", t, "", c.Usubjid))
+			}
+
+		case DateOrder:
+			var eligible []int
+			for _, i := range all {
+				if out[i].Brthdtc != nil {
+					eligible = append(eligible, i)
+				}
+			}
+			for _, i := range in.pick(eligible, in.count(len(eligible))) {
+				d := out[i]
+				orig := CPUtils.DateP2Str(d.Brthdtc)
+				b := d.Dmdtc.AddDate(0, 0, in.rnd.Intn(30)+1)
+				d.Brthdtc = &b
+				in.record(dmEntry(d, "BRTHDTC", t, orig, CPUtils.DateP2Str(d.Brthdtc)))
+			}
+
+		case InvalidCode:
+			for _, i := range in.pick(all, in.count(len(all))) {
+				d := out[i]
+				switch in.rnd.Intn(3) {
+				case 0:
+					orig := CPUtils.StrP2Str(d.Sex)
+					s := in.choice(badSex)
+					d.Sex = &s
+					in.record(dmEntry(d, "SEX", t, orig, s))
+				case 1:
+					orig := CPUtils.StrP2Str(d.Race)
+					r := in.choice(badRace)
+					d.Race = &r
+					in.record(dmEntry(d, "RACE", t, orig, r))
+				default:
+					orig := d.Country
+					d.Country = in.choice(badCountry)
+					in.record(dmEntry(d, "COUNTRY", t, orig, d.Country))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// Write the manifest of injected anomalies to a CSV file
+func (in *Injector) WriteManifest(outfile *string) {
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fo.Close()
+
+	w := bufio.NewWriter(fo)
+	_, err = w.WriteString("ID,DOMAIN,USUBJID,SEQ,VISITNUM,VARIABLE,TYPE,ORIGINAL,INJECTED\n")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, e := range in.Manifest {
+		_, err := w.WriteString(strings.Join([]string{
+			strconv.Itoa(e.Id),
+			e.Domain,
+			e.Usubjid,
+			CPUtils.IntP2Str(e.Seq),
+			CPUtils.IntP2Str(e.Visitnum),
+			e.Variable,
+			e.Type,
+			e.Original,
+			e.Injected,
+		}, ",") + "\n")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	w.Flush()
+}
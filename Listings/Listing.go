@@ -0,0 +1,318 @@
+// Listings of any data set.
+//
+// A listing is declared by a Spec: the variables of the data set, the
+// records selected, the by-groups, each starting a new page with its
+// caption, the sort order within them and the columns shown, with their
+// headers, widths and display formats. The spec is normally read from a
+// spec file, so that the listing of a new domain (e.g. VS or AE) needs no
+// new program. Build lays out records of any data set that implements
+// Tables.Row as a Report.Listing, which the Report package paginates.
+package Listings
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phil0lucas/GoForCP/Report"
+	"github.com/phil0lucas/GoForCP/Tables"
+)
+
+// A listing spec file is a CSV file with no header and one line per item,
+// its type first. Blank lines and lines starting with # are ignored.
+// Types:
+// - VARS    the names of the variables of the data file, in field order
+// - WHERE   var,values: records with one of the values, separated by |,
+//           are listed. Several WHERE lines must all hold.
+// - BY      var,label: a by-group variable, in order, the caption of a
+//           group being "label: value"
+// - SORT    var,...: the sort keys within a by-group, in order
+// - COLUMN  var,header,width,just,format: a column of the listing, in
+//           order. A width of 0 is measured from the values; just is L, C
+//           or R. The format is the rest of the line (see Format).
+// - KEEP    repeated values of the first column are shown once and their
+//           rows are kept together on a page
+const (
+	SpecVars   = "VARS"
+	SpecWhere  = "WHERE"
+	SpecBy     = "BY"
+	SpecSort   = "SORT"
+	SpecColumn = "COLUMN"
+	SpecKeep   = "KEEP"
+)
+
+// A selection of records by the values of a variable
+type Where struct {
+	Var    string
+	Values []string
+}
+
+// A by-group variable and its label in the group caption
+type By struct {
+	Var   string
+	Label string
+}
+
+// A column of a listing: the variable shown, its header, width in mm (0 to
+// measure it), justification and display format
+type Column struct {
+	Var    string
+	Header string
+	Width  float64
+	Just   string
+	Format string
+}
+
+// The declaration of a listing
+type Spec struct {
+	Vars    []string
+	Where   []Where
+	By      []By
+	Sort    []string
+	Columns []Column
+	Keep    bool
+}
+
+// Read a listing spec file. The variables named are checked against the
+// VARS of the file, if given.
+func ReadSpec(infile *string) *Spec {
+	file, err := os.Open(*infile)
+	if err != nil {
+		panic(fmt.Sprintf("error opening %s: %v", *infile, err))
+	}
+	defer file.Close()
+
+	s := &Spec{}
+	var named []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, ",")
+		typ := strings.ToUpper(f[0])
+		switch {
+		case typ == SpecVars:
+			for _, v := range f[1:] {
+				s.Vars = append(s.Vars, strings.ToUpper(strings.TrimSpace(v)))
+			}
+		case typ == SpecWhere && len(f) >= 3:
+			v := strings.ToUpper(f[1])
+			s.Where = append(s.Where, Where{Var: v, Values: strings.Split(strings.Join(f[2:], ","), "|")})
+			named = append(named, v)
+		case typ == SpecBy && len(f) >= 3:
+			v := strings.ToUpper(f[1])
+			s.By = append(s.By, By{Var: v, Label: strings.Join(f[2:], ",")})
+			named = append(named, v)
+		case typ == SpecSort && len(f) >= 2:
+			for _, v := range f[1:] {
+				s.Sort = append(s.Sort, strings.ToUpper(strings.TrimSpace(v)))
+			}
+			named = append(named, s.Sort...)
+		case typ == SpecColumn && len(f) >= 5:
+			f = strings.SplitN(line, ",", 6)
+			w, err := strconv.ParseFloat(f[3], 64)
+			if err != nil {
+				panic(fmt.Sprintf("%s: invalid width: %s", *infile, line))
+			}
+			c := Column{Var: strings.ToUpper(f[1]), Header: f[2], Width: w, Just: strings.ToUpper(f[4])}
+			if len(f) == 6 {
+				c.Format = f[5]
+			}
+			s.Columns = append(s.Columns, c)
+			named = append(named, c.Var)
+		case typ == SpecKeep:
+			s.Keep = true
+		default:
+			panic(fmt.Sprintf("%s: invalid line: %s", *infile, line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Sprintf("error reading %s: %v", *infile, err))
+	}
+	if len(s.Columns) == 0 {
+		panic(fmt.Sprintf("%s: no columns", *infile))
+	}
+	if len(s.Vars) > 0 {
+		for _, v := range named {
+			if indexOf(s.Vars, v) < 0 {
+				panic(fmt.Sprintf("%s: unknown variable %s", *infile, v))
+			}
+		}
+	}
+	return s
+}
+
+// The index of a string in a slice, -1 if absent
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// A record of a data file read by the variables of a spec
+type Record map[string]string
+
+// The value of a variable, blank if the record has no such field
+func (r Record) Get(name string) string {
+	return r[strings.ToUpper(name)]
+}
+
+// Read a CSV data file with no header, its fields named by the variables
+func Read(infile *string, vars []string) []Tables.Row {
+	file, err := os.Open(*infile)
+	if err != nil {
+		panic(fmt.Sprintf("error opening %s: %v", *infile, err))
+	}
+	defer file.Close()
+
+	var rows []Tables.Row
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		f := strings.Split(scanner.Text(), ",")
+		r := make(Record)
+		for i, v := range vars {
+			if i < len(f) {
+				r[v] = f[i]
+			}
+		}
+		rows = append(rows, r)
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Sprintf("error reading %s: %v", *infile, err))
+	}
+	return rows
+}
+
+// Display a value in a format:
+// - blank: as it is
+// - a printf verb for a number, e.g. %.1f; values that are not numbers are
+//   shown as they are
+// - DATE9.: a date yyyy-mm-dd as ddMONyyyy, e.g. 04NOV2010
+// - a decode of values, e.g. M=Male|F=Female; values not in it are shown
+//   as they are
+func Format(v string, format string) string {
+	switch {
+	case format == "" || v == "":
+		return v
+	case strings.HasPrefix(format, "%"):
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return v
+		}
+		return fmt.Sprintf(format, x)
+	case strings.ToUpper(format) == "DATE9.":
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return v
+		}
+		return strings.ToUpper(t.Format("02Jan2006"))
+	case strings.Contains(format, "="):
+		for _, d := range strings.Split(format, "|") {
+			kv := strings.SplitN(d, "=", 2)
+			if len(kv) == 2 && kv[0] == v {
+				return kv[1]
+			}
+		}
+	}
+	return v
+}
+
+// Compare two values, as numbers if both are numbers
+func compare(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil && x < y:
+		return -1
+	case errA == nil && errB == nil && x > y:
+		return 1
+	case errA == nil && errB == nil:
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// Whether a record is selected by the WHERE items of a spec
+func (s *Spec) selected(r Tables.Row) bool {
+	for _, w := range s.Where {
+		if indexOf(w.Values, r.Get(w.Var)) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// The caption of the by-group of a record
+func (s *Spec) caption(r Tables.Row) string {
+	var parts []string
+	for _, b := range s.By {
+		parts = append(parts, b.Label+": "+r.Get(b.Var))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Lay out the selected records as a listing: sorted by the by-group
+// variables then the sort keys, a group for each by-group value (one group
+// if there are no BY items) and a row for each record
+func Build(s *Spec, rows []Tables.Row) *Report.Listing {
+	var sel []Tables.Row
+	for _, r := range rows {
+		if s.selected(r) {
+			sel = append(sel, r)
+		}
+	}
+	var keys []string
+	for _, b := range s.By {
+		keys = append(keys, b.Var)
+	}
+	keys = append(keys, s.Sort...)
+	sort.SliceStable(sel, func(i, j int) bool {
+		for _, k := range keys {
+			if c := compare(sel[i].Get(k), sel[j].Get(k)); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	l := &Report.Listing{Keep: s.Keep}
+	for _, c := range s.Columns {
+		l.Columns = append(l.Columns, Report.Column{Header: c.Header, Width: c.Width, Just: c.Just})
+	}
+	var g *Report.Group
+	prev := ""
+	for _, r := range sel {
+		capt := s.caption(r)
+		if g == nil || capt != g.Caption {
+			l.Groups = append(l.Groups, Report.Group{Caption: capt})
+			g = &l.Groups[len(l.Groups)-1]
+			prev = ""
+		}
+		var row []string
+		for _, c := range s.Columns {
+			row = append(row, Format(r.Get(c.Var), c.Format))
+		}
+		// 	With Keep, the first value is shown on the first row of its block
+		if s.Keep {
+			if row[0] == prev {
+				row[0] = ""
+			} else {
+				prev = row[0]
+			}
+		}
+		g.Rows = append(g.Rows, row)
+	}
+	return l
+}
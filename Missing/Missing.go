@@ -0,0 +1,266 @@
+// Missing-data engine for the generated domains.
+//
+// Each rule applies to one variable of one domain and defines the mechanism
+// by which a value becomes missing:
+// - MCAR  Missing Completely At Random. A fixed probability (RATE).
+// - MAR   Missing At Random. The probability RATE is multiplied by FACTOR when
+//         an observed covariate (e.g. AGE, ARM) meets the condition.
+// - MNAR  Missing Not At Random. The probability RATE is multiplied by FACTOR
+//         when the (unobserved) value itself meets the condition, e.g. high
+//         blood pressures dropping out.
+//
+// Rules are read from a CSV specification file, one rule per row:
+// - DOMAIN     Char  Domain abbreviation e.g. DM, VS
+// - VARIABLE   Char  Variable name e.g. AGE, SEX or a VS test code e.g. SBP
+// - MECHANISM  Char  MCAR, MAR or MNAR
+// - RATE       Num   Base probability of a missing value (0 to 1)
+// - COVARIATE  Char  Name of the covariate for MAR (blank otherwise)
+// - CONDITION  Char  Value to compare with e.g. Active, >=60, <100 (blank for MCAR)
+// - FACTOR     Num   Multiplier of RATE when the condition is met (blank for MCAR)
+//
+// Example:
+//	DM,AGE,MCAR,0.05,,,
+//	DM,SEX,MAR,0.05,COUNTRY,USA,2
+//	VS,SBP,MAR,0.02,AGE,>=65,3
+//	VS,SBP,MNAR,0.02,,>=150,4
+//
+// Lines starting with # are comments.
+// Covariates available to MAR rules:
+// - DM  SITEID, COUNTRY, INVID, AGE, SEX, RACE, ARM
+// - VS  SITEID, ARM, AGE, SEX, COUNTRY, VISITNUM, VSPOS, VSTPTNUM and
+//       BASELINE (the subject's screening value for the test)
+//
+// When no specification file is given the previous behaviour is kept i.e.
+// AGE, SEX and RACE in DM are each missing completely at random for 5% of
+// subjects and nothing else is missing.
+package Missing
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Missing-data mechanisms
+const (
+	MCAR = "MCAR"
+	MAR  = "MAR"
+	MNAR = "MNAR"
+)
+
+// A single rule as read from the specification file.
+type Rule struct {
+	Domain    string
+	Variable  string
+	Mechanism string
+	Rate      float64
+	Covariate string
+	Condition string
+	Factor    float64
+}
+
+// The engine holds the rules keyed by domain and variable.
+// More than one rule may apply to the same variable, e.g. an MCAR baseline
+// rate together with an MNAR rule for high values.
+type Engine struct {
+	rules map[key][]Rule
+	rnd   *rand.Rand
+}
+
+// Compound key of domain and variable
+type key struct {
+	domain   string
+	variable string
+}
+
+// The rules used when no specification file is given.
+var defaultRules = []Rule{
+	{Domain: "DM", Variable: "AGE", Mechanism: MCAR, Rate: 0.05},
+	{Domain: "DM", Variable: "SEX", Mechanism: MCAR, Rate: 0.05},
+	{Domain: "DM", Variable: "RACE", Mechanism: MCAR, Rate: 0.05},
+}
+
+// Create an engine from a slice of rules.
+func New(rules []Rule) *Engine {
+	e := &Engine{
+		rules: make(map[key][]Rule),
+		rnd:   rand.New(rand.NewSource(time.Now().UTC().UnixNano())),
+	}
+	for _, r := range rules {
+		k := key{strings.ToUpper(r.Domain), strings.ToUpper(r.Variable)}
+		e.rules[k] = append(e.rules[k], r)
+	}
+	return e
+}
+
+// The engine with the default rules
+func Default() *Engine {
+	return New(defaultRules)
+}
+
+// Read the specification file into an engine.
+// A nil or blank file name gives the default engine.
+func ReadSpec(infile *string) *Engine {
+	if infile == nil || *infile == "" {
+		return Default()
+	}
+
+	file, err := os.Open(*infile)
+	if err != nil {
+		panic(fmt.Sprintf("error opening %s: %v", *infile, err))
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	var rules []Rule
+	for i := 1; scanner.Scan(); i++ {
+		str := strings.TrimSpace(scanner.Text())
+		if str == "" || strings.HasPrefix(str, "#") {
+			continue
+		}
+		r, err := parseRule(str)
+		if err != nil {
+			panic(fmt.Sprintf("%s line %d: %v", *infile, i, err))
+		}
+		rules = append(rules, r)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "error reading from file:", err)
+		os.Exit(3)
+	}
+	return New(rules)
+}
+
+// Split a row of the specification file into a Rule, validating as we go
+func parseRule(str string) (Rule, error) {
+	var r Rule
+	s := strings.Split(str, ",")
+	if len(s) < 4 {
+		return r, fmt.Errorf("expected at least 4 fields, found %d", len(s))
+	}
+	// Pad out the optional trailing fields
+	for len(s) < 7 {
+		s = append(s, "")
+	}
+	r.Domain = strings.ToUpper(strings.TrimSpace(s[0]))
+	r.Variable = strings.ToUpper(strings.TrimSpace(s[1]))
+	r.Mechanism = strings.ToUpper(strings.TrimSpace(s[2]))
+
+	rate, err := strconv.ParseFloat(strings.TrimSpace(s[3]), 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return r, fmt.Errorf("invalid rate %q", s[3])
+	}
+	r.Rate = rate
+	r.Covariate = strings.ToUpper(strings.TrimSpace(s[4]))
+	r.Condition = strings.TrimSpace(s[5])
+
+	switch r.Mechanism {
+	case MCAR:
+		r.Factor = 1
+	case MAR, MNAR:
+		if r.Mechanism == MAR && r.Covariate == "" {
+			return r, fmt.Errorf("MAR rule for %s needs a covariate", r.Variable)
+		}
+		if r.Condition == "" {
+			return r, fmt.Errorf("%s rule for %s needs a condition", r.Mechanism, r.Variable)
+		}
+		factor, err := strconv.ParseFloat(strings.TrimSpace(s[6]), 64)
+		if err != nil || factor < 0 {
+			return r, fmt.Errorf("invalid factor %q", s[6])
+		}
+		r.Factor = factor
+	default:
+		return r, fmt.Errorf("unknown mechanism %q", s[2])
+	}
+	return r, nil
+}
+
+// Determine whether a condition such as "Active", ">=60" or "<100" is met by a value.
+// Numeric comparisons need both sides to be numbers, otherwise the comparison
+// is a case-insensitive string equality.
+func match(cond string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(cond, op) {
+			rhs := strings.TrimSpace(cond[len(op):])
+			a, errA := strconv.ParseFloat(value, 64)
+			b, errB := strconv.ParseFloat(rhs, 64)
+			if errA != nil || errB != nil {
+				switch op {
+				case "=":
+					return strings.EqualFold(value, rhs)
+				case "!=":
+					return !strings.EqualFold(value, rhs)
+				}
+				return false
+			}
+			switch op {
+			case ">=":
+				return a >= b
+			case "<=":
+				return a <= b
+			case "!=":
+				return a != b
+			case ">":
+				return a > b
+			case "<":
+				return a < b
+			default:
+				return a == b
+			}
+		}
+	}
+	return strings.EqualFold(value, cond)
+}
+
+// The probability that a value is missing under a single rule.
+// cov holds the observed covariates of the record, value the value itself.
+func (r Rule) prob(cov map[string]string, value string) float64 {
+	p := r.Rate
+	switch r.Mechanism {
+	case MAR:
+		if match(r.Condition, cov[r.Covariate]) {
+			p *= r.Factor
+		}
+	case MNAR:
+		if match(r.Condition, value) {
+			p *= r.Factor
+		}
+	}
+	if p > 1 {
+		p = 1
+	}
+	return p
+}
+
+// Determine whether the variable of a domain should be set to missing.
+// cov is a map of the covariates observed for the record (keys in upper case
+// e.g. AGE, SEX, ARM, COUNTRY, SITEID, VISITNUM) and value is the
+// generated value in character form. A variable with no rules is never missing.
+// Each applicable rule is tried in turn; the value is missing if any of them fires.
+func (e *Engine) Flag(domain, variable string, cov map[string]string, value string) bool {
+	if e == nil {
+		return false
+	}
+	for _, r := range e.rules[key{strings.ToUpper(domain), strings.ToUpper(variable)}] {
+		if e.rnd.Float64() < r.prob(cov, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Determine whether any rules exist for a variable of a domain
+func (e *Engine) Has(domain, variable string) bool {
+	if e == nil {
+		return false
+	}
+	return len(e.rules[key{strings.ToUpper(domain), strings.ToUpper(variable)}]) > 0
+}
@@ -0,0 +1,139 @@
+package Report
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TFL metadata: the text and the program of each output, looked up by the
+// output ID, so that wording can be changed without changing the programs.
+// The metadata file is a CSV file with no header and one line per item:
+//
+//	id,type,seq,text
+//
+// The text is the rest of the line and may contain commas. Lines with an
+// id of * apply to all outputs unless the output has its own line of the
+// same type and seq. Blank lines and lines starting with # are ignored.
+// Types:
+// - HEADER     seq 1 to 6: head 1 left and right, head 2 left and right,
+//              head 3 left and head 4 centre, normally given for all outputs
+// - TITLE      the title, head 5 centre
+// - POPULATION the population, head 6 centre
+// - FOOTNOTE   seq 1 to 3: the footnotes
+// - PROGRAM    the program creating the output, e.g. sum.go
+// - ARGS       the arguments of the program, separated by spaces
+// Text may hold values substituted by the program, written as {name}.
+const (
+	MetaHeader     = "HEADER"
+	MetaTitle      = "TITLE"
+	MetaPopulation = "POPULATION"
+	MetaFootnote   = "FOOTNOTE"
+	MetaProgram    = "PROGRAM"
+	MetaArgs       = "ARGS"
+)
+
+// The output ID of lines for all outputs
+const allOutputs = "*"
+
+// Key of an item of an output
+type metaKey struct {
+	typ string
+	seq int
+}
+
+// The TFL metadata, with the output IDs in file order
+type Meta struct {
+	IDs   []string
+	items map[string]map[metaKey]string
+}
+
+// Read the TFL metadata file
+func ReadMeta(infile *string) *Meta {
+	file, err := os.Open(*infile)
+	if err != nil {
+		panic(fmt.Sprintf("error opening %s: %v", *infile, err))
+	}
+	defer file.Close()
+
+	m := &Meta{items: make(map[string]map[metaKey]string)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s := strings.SplitN(line, ",", 4)
+		if len(s) < 4 {
+			panic(fmt.Sprintf("%s: invalid line: %s", *infile, line))
+		}
+		seq, err := strconv.Atoi(s[2])
+		if err != nil {
+			panic(fmt.Sprintf("%s: invalid seq: %s", *infile, line))
+		}
+		id := s[0]
+		if _, ok := m.items[id]; !ok {
+			m.items[id] = make(map[metaKey]string)
+			if id != allOutputs {
+				m.IDs = append(m.IDs, id)
+			}
+		}
+		m.items[id][metaKey{strings.ToUpper(s[1]), seq}] = s[3]
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Sprintf("error reading %s: %v", *infile, err))
+	}
+	return m
+}
+
+// Whether an output ID is in the metadata
+func (m *Meta) Has(id string) bool {
+	_, ok := m.items[id]
+	return ok && id != allOutputs
+}
+
+// The text of an item of an output with the values substituted
+func (m *Meta) Text(id string, typ string, seq int, vars map[string]string) string {
+	k := metaKey{typ, seq}
+	t, ok := m.items[id][k]
+	if !ok {
+		t = m.items[allOutputs][k]
+	}
+	for name, v := range vars {
+		t = strings.Replace(t, "{"+name+"}", v, -1)
+	}
+	return t
+}
+
+// The headers of an output
+func (m *Meta) Headers(id string, vars map[string]string) *Headers {
+	return &Headers{
+		Head1Left:   m.Text(id, MetaHeader, 1, vars),
+		Head1Right:  m.Text(id, MetaHeader, 2, vars),
+		Head2Left:   m.Text(id, MetaHeader, 3, vars),
+		Head2Right:  m.Text(id, MetaHeader, 4, vars),
+		Head3Left:   m.Text(id, MetaHeader, 5, vars),
+		Head4Centre: m.Text(id, MetaHeader, 6, vars),
+		Head5Centre: m.Text(id, MetaTitle, 1, vars),
+		Head6Centre: m.Text(id, MetaPopulation, 1, vars),
+	}
+}
+
+// The footers of an output
+func (m *Meta) Footers(id string, vars map[string]string) *Footers {
+	return &Footers{
+		Foot1Left: m.Text(id, MetaFootnote, 1, vars),
+		Foot2Left: m.Text(id, MetaFootnote, 2, vars),
+		Foot3Left: m.Text(id, MetaFootnote, 3, vars),
+	}
+}
+
+// A new document with the headers and footers of an output
+func NewFromMeta(m *Meta, id string, vars map[string]string) *Doc {
+	if !m.Has(id) {
+		panic(fmt.Sprintf("output %s is not in the TFL metadata", id))
+	}
+	return New(m.Headers(id, vars), m.Footers(id, vars))
+}
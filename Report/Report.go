@@ -0,0 +1,194 @@
+// Reporting of tables, listings and figures (TFLs).
+//
+// An output is built as a Doc: the standard header and footer text and a
+// sequence of blocks, each starting on a new page:
+// - Table: column headers (optionally under headers spanning columns) and
+//   rows of display strings, continued on new pages as needed with the
+//   caption and headers repeated.
+// - Listing: a table in groups (e.g. by treatment arm), each group starting
+//   a new page with its caption.
+// - Figure: a picture file with an optional table and lines of text below.
+// The package owns the page setup and the header and footer blocks,
+// including the page numbering "Page x of y", the program name and the run
+// timestamp (not in plain text, which is for diff). A document is written as PDF, RTF, DOCX, HTML or plain text,
+// or saved (gob) to be bundled with others into one PDF.
+package Report
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/phil0lucas/GoForCP/CPUtils"
+)
+
+// Output formats, also the file name extensions
+const (
+	FormatPDF  = "pdf"
+	FormatRTF  = "rtf"
+	FormatDOCX = "docx"
+	FormatHTML = "html"
+	FormatText = "txt"
+	FormatGob  = "gob"
+)
+
+// The header text, in six lines. Lines 1 and 2 have left and right parts;
+// lines 4 to 6 are centred, line 5 being the title of the output and line 6
+// the population.
+type Headers struct {
+	Head1Left   string
+	Head1Right  string
+	Head2Left   string
+	Head2Right  string
+	Head3Left   string
+	Head4Centre string
+	Head5Centre string
+	Head6Centre string
+}
+
+// The footnotes, in three lines above the line with the page number,
+// program name and run timestamp
+type Footers struct {
+	Foot1Left string
+	Foot2Left string
+	Foot3Left string
+}
+
+// A column of a table: the header, the width in mm, zero to measure it from
+// the values, and the justification of the values (L, C or R). The values of
+// a column of numbers are aligned on their decimal points and justified as
+// a block.
+type Column struct {
+	Header string
+	Width  float64
+	Just   string
+}
+
+// A header spanning columns, starting at column From (0 based) for Span columns
+type Span struct {
+	Header string
+	From   int
+	Span   int
+}
+
+// A block of an output
+type Block interface {
+	isBlock()
+}
+
+// A table. Caption, if not blank, is shown above the column headers on each
+// page, marked as continued after the first. A nil row leaves a blank line.
+// Keep keeps each row with a label in the first cell together on a page with
+// the rows below it that have none (see the layout of pages).
+type Table struct {
+	Caption string
+	Spans   []Span
+	Columns []Column
+	Rows    [][]string
+	Keep    bool
+}
+
+// A group of the rows of a listing, shown under its caption
+type Group struct {
+	Caption string
+	Rows    [][]string
+}
+
+// A listing, the rows in groups with the same columns. Keep is as for tables.
+type Listing struct {
+	Columns []Column
+	Groups  []Group
+	Keep    bool
+}
+
+// A figure from a picture file (PNG), its size in mm. Columns and Rows, if
+// given, are a table shown without rules below the figure (e.g. the number
+// at risk under a Kaplan-Meier plot), followed by the lines of Text.
+type Figure struct {
+	File    string
+	Width   float64
+	Height  float64
+	Columns []Column
+	Rows    [][]string
+	Text    []string
+}
+
+// The groups of a listing as tables, the widths of the columns measured
+// over all groups
+func (l *Listing) tables() []*Table {
+	var rows [][]string
+	for _, g := range l.Groups {
+		rows = append(rows, alignRows(g.Rows, len(l.Columns))...)
+	}
+	cols := measure(l.Columns, nil, rows)
+	var ts []*Table
+	for _, g := range l.Groups {
+		ts = append(ts, &Table{Caption: g.Caption, Columns: cols, Rows: g.Rows, Keep: l.Keep})
+	}
+	return ts
+}
+
+// The table below a figure laid out as a table
+func (f *Figure) table() *Table {
+	return (&Table{Columns: f.Columns, Rows: f.Rows}).laidOut()
+}
+
+func (*Table) isBlock()   {}
+func (*Listing) isBlock() {}
+func (*Figure) isBlock()  {}
+
+// An output document
+type Doc struct {
+	Headers *Headers
+	Footers *Footers
+	Program string
+	Run     string
+	Blocks  []Block
+}
+
+// A new document, stamped with the running program and time
+func New(h *Headers, f *Footers) *Doc {
+	return &Doc{
+		Headers: h,
+		Footers: f,
+		Program: CPUtils.GetCurrentProgram(),
+		Run:     "Run: " + CPUtils.TimeStamp(),
+	}
+}
+
+// Add a table to the document
+func (d *Doc) AddTable(t *Table) {
+	d.Blocks = append(d.Blocks, t)
+}
+
+// Add a listing to the document
+func (d *Doc) AddListing(l *Listing) {
+	d.Blocks = append(d.Blocks, l)
+}
+
+// Add a figure to the document
+func (d *Doc) AddFigure(f *Figure) {
+	d.Blocks = append(d.Blocks, f)
+}
+
+// Write the document in a format, the extension of the file name being
+// replaced by that of the format
+func (d *Doc) Write(outfile *string, format string) error {
+	format = strings.ToLower(format)
+	name := strings.TrimSuffix(*outfile, filepath.Ext(*outfile)) + "." + format
+	switch format {
+	case FormatPDF:
+		return d.WritePDF(&name)
+	case FormatRTF:
+		return d.WriteRTF(&name)
+	case FormatDOCX:
+		return d.WriteDOCX(&name)
+	case FormatHTML:
+		return d.WriteHTML(&name)
+	case FormatText:
+		return d.WriteText(&name)
+	case FormatGob:
+		return d.WriteGob(&name)
+	}
+	return fmt.Errorf("unknown output format %s", format)
+}
@@ -0,0 +1,146 @@
+package Report
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"os"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// A bundle is a single PDF of several outputs: a table of contents, then
+// each output on its own pages with its own headers and footnotes. The pages
+// are numbered through the bundle, each output has a bookmark and each entry
+// of the contents links to the first page of its output. The outputs are
+// written by their programs in the gob format, a saved document, and read
+// back by the bundler; the picture files of figures must still be there.
+
+// The blocks of a saved document
+func init() {
+	gob.Register(&Table{})
+	gob.Register(&Listing{})
+	gob.Register(&Figure{})
+}
+
+// Save the document to a file, to be read back with ReadDoc
+func (d *Doc) WriteGob(outfile *string) error {
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	w := bufio.NewWriter(fo)
+	if err := gob.NewEncoder(w).Encode(d); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Read a document saved with WriteGob. The blank lines of tables, saved as
+// empty rows, are nil rows again.
+func ReadDoc(infile *string) *Doc {
+	file, err := os.Open(*infile)
+	if err != nil {
+		panic(fmt.Sprintf("error opening %s: %v", *infile, err))
+	}
+	defer file.Close()
+
+	d := &Doc{}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(d); err != nil {
+		panic(fmt.Sprintf("error reading %s: %v", *infile, err))
+	}
+	blank := func(rows [][]string) {
+		for i, row := range rows {
+			if len(row) == 0 {
+				rows[i] = nil
+			}
+		}
+	}
+	for _, b := range d.Blocks {
+		switch b := b.(type) {
+		case *Table:
+			blank(b.Rows)
+		case *Listing:
+			for _, g := range b.Groups {
+				blank(g.Rows)
+			}
+		}
+	}
+	return d
+}
+
+// An output of a bundle, its ID and its document
+type Output struct {
+	ID  string
+	Doc *Doc
+}
+
+// Layout of the table of contents: the entries on a page, and the widths of
+// the ID and page number columns, the title taking the rest of the page
+const (
+	tocLines = 30
+	tocID    = 40.0
+	tocPage  = 25.0
+)
+
+// Write the outputs to one PDF file after a table of contents, which has the
+// headers and footers of the toc document
+func WriteBundle(outfile *string, toc *Doc, outputs []Output) error {
+	if len(outputs) == 0 {
+		return fmt.Errorf("no outputs to bundle")
+	}
+
+	// 	The pages of each output, counted by writing it alone
+	pages := make([]int, len(outputs))
+	for i, o := range outputs {
+		pdf := newPDF(func() *Doc { return o.Doc })
+		pdfBlocks(pdf, o.Doc)
+		if err := pdf.Error(); err != nil {
+			return fmt.Errorf("%s: %v", o.ID, err)
+		}
+		pages[i] = pdf.PageNo()
+	}
+
+	// 	A bookmark is added with the first page of each output, as the header
+	// 	of the page is written
+	var pdf *gofpdf.Fpdf
+	cur := toc
+	mark := "Table of Contents"
+	pdf = newPDF(func() *Doc {
+		if mark != "" {
+			pdf.Bookmark(mark, 0, 0)
+			mark = ""
+		}
+		return cur
+	})
+
+	// 	Contents, linked to the first pages of the outputs
+	first := (len(outputs)+tocLines-1)/tocLines + 1
+	title := pageWidth - tocID - tocPage
+	for i, o := range outputs {
+		if i%tocLines == 0 {
+			pdf.AddPage()
+			pdf.CellFormat(tocID, 8, "Output", "TB", 0, "L", false, 0, "")
+			pdf.CellFormat(title, 8, "Title", "TB", 0, "L", false, 0, "")
+			pdf.CellFormat(tocPage, 8, "Page", "TB", 0, "R", false, 0, "")
+			pdf.Ln(8)
+		}
+		link := pdf.AddLink()
+		pdf.SetLink(link, 0, first)
+		pdf.CellFormat(tocID, 8, o.ID, "", 0, "L", false, link, "")
+		pdf.CellFormat(title, 8, o.Doc.Headers.Head5Centre, "", 0, "L", false, link, "")
+		pdf.CellFormat(tocPage, 8, fmt.Sprint(first), "", 0, "R", false, link, "")
+		pdf.Ln(rowHeight)
+		first += pages[i]
+	}
+
+	for _, o := range outputs {
+		cur = o.Doc
+		mark = o.ID + " " + o.Doc.Headers.Head5Centre
+		pdfBlocks(pdf, o.Doc)
+	}
+
+	// 	Output
+	return pdf.OutputFileAndClose(*outfile)
+}
@@ -0,0 +1,376 @@
+package Report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Page layout of the DOCX, as the RTF in twips. Figures are sized in EMUs.
+const (
+	emuPerMM = 36000
+	wordNS   = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+	drawingNS = `xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing" ` +
+		`xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" ` +
+		`xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture"`
+	relNS   = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xmlDecl = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+// The fixed parts of the package: the content types, the package
+// relationships and the styles, Courier New 10 point with no paragraph spacing
+const docxContentTypes = xmlDecl +
+	`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Default Extension="png" ContentType="image/png"/>` +
+	`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+	`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+	`<Override PartName="/word/header1.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.header+xml"/>` +
+	`<Override PartName="/word/footer1.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.footer+xml"/>` +
+	`</Types>`
+
+const docxRels = xmlDecl +
+	`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="` + relNS + `/officeDocument" Target="word/document.xml"/>` +
+	`</Relationships>`
+
+const docxStyles = xmlDecl +
+	`<w:styles ` + wordNS + `><w:docDefaults>` +
+	`<w:rPrDefault><w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/>` +
+	`<w:sz w:val="20"/><w:szCs w:val="20"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr></w:pPrDefault>` +
+	`</w:docDefaults></w:styles>`
+
+// Escape text for XML
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// The justification of a column as paragraph alignment
+func docxAlign(just string) string {
+	switch just {
+	case "C":
+		return "center"
+	case "R":
+		return "right"
+	}
+	return "left"
+}
+
+// A paragraph of text with an alignment and tab stops (position and type)
+// and optional further paragraph properties
+func docxPara(text string, align string, tabs [][2]string, ppr string) string {
+	var b bytes.Buffer
+	b.WriteString(`<w:p><w:pPr>`)
+	if len(tabs) > 0 {
+		b.WriteString(`<w:tabs>`)
+		for _, t := range tabs {
+			fmt.Fprintf(&b, `<w:tab w:val="%s" w:pos="%s"/>`, t[1], t[0])
+		}
+		b.WriteString(`</w:tabs>`)
+	}
+	b.WriteString(ppr)
+	fmt.Fprintf(&b, `<w:jc w:val="%s"/></w:pPr>`, align)
+	b.WriteString(docxRuns(text))
+	b.WriteString(`</w:p>`)
+	return b.String()
+}
+
+// The runs of a text, a tab character starting a new run with a tab
+func docxRuns(text string) string {
+	var b bytes.Buffer
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i == len(text) || text[i] == '\t' {
+			if i > start {
+				fmt.Fprintf(&b, `<w:r><w:t xml:space="preserve">%s</w:t></w:r>`, xmlEscape(text[start:i]))
+			}
+			if i < len(text) {
+				b.WriteString(`<w:r><w:tab/></w:r>`)
+			}
+			start = i + 1
+		}
+	}
+	return b.String()
+}
+
+// Write the document to a DOCX file. Tables are native Word tables, the
+// caption and column headers being header rows repeated on each page, the
+// headers and footnotes are the section header and footer, and figures are
+// embedded pictures.
+func (d *Doc) WriteDOCX(outfile *string) error {
+	h, f := d.Headers, d.Footers
+	right := fmt.Sprint(rtfPaperW - 2*rtfMargin)
+
+	// 	Section header: lines 1 and 2 left and right, line 3 left, lines 4 to 6 centred
+	var hdr bytes.Buffer
+	hdr.WriteString(xmlDecl + `<w:hdr ` + wordNS + `>`)
+	rtab := [][2]string{{right, "right"}}
+	hdr.WriteString(docxPara(h.Head1Left+"\t"+h.Head1Right, "left", rtab, ""))
+	hdr.WriteString(docxPara(h.Head2Left+"\t"+h.Head2Right, "left", rtab, ""))
+	hdr.WriteString(docxPara(h.Head3Left, "left", nil, ""))
+	hdr.WriteString(docxPara(h.Head4Centre, "center", nil, ""))
+	hdr.WriteString(docxPara(h.Head5Centre, "center", nil, ""))
+	hdr.WriteString(docxPara(h.Head6Centre, "center", nil, ""))
+	hdr.WriteString(`</w:hdr>`)
+
+	// 	Section footer: a rule above the footnotes, and the page number as "Page x of y"
+	var ftr bytes.Buffer
+	ftr.WriteString(xmlDecl + `<w:ftr ` + wordNS + `>`)
+	ftr.WriteString(docxPara(f.Foot1Left, "left", nil,
+		`<w:pBdr><w:top w:val="single" w:sz="4" w:space="1" w:color="auto"/></w:pBdr>`))
+	ftr.WriteString(docxPara(f.Foot2Left, "left", nil, ""))
+	ftr.WriteString(docxPara(f.Foot3Left, "left", nil, ""))
+	ftr.WriteString(`<w:p><w:pPr><w:tabs>`)
+	fmt.Fprintf(&ftr, `<w:tab w:val="left" w:pos="%d"/><w:tab w:val="right" w:pos="%s"/>`, twips(30), right)
+	ftr.WriteString(`</w:tabs></w:pPr>`)
+	ftr.WriteString(docxRuns("Page "))
+	ftr.WriteString(`<w:fldSimple w:instr="PAGE"><w:r><w:t>1</w:t></w:r></w:fldSimple>`)
+	ftr.WriteString(docxRuns(" of "))
+	ftr.WriteString(`<w:fldSimple w:instr="NUMPAGES"><w:r><w:t>1</w:t></w:r></w:fldSimple>`)
+	ftr.WriteString(docxRuns("\t" + d.Program + "\t" + d.Run))
+	ftr.WriteString(`</w:p></w:ftr>`)
+
+	// 	Body, each block starting a new page, and the pictures of the figures
+	var body bytes.Buffer
+	var media [][]byte
+	body.WriteString(xmlDecl + `<w:document ` + wordNS + ` ` + drawingNS + `><w:body>`)
+	pageBreak := `<w:p><w:r><w:br w:type="page"/></w:r></w:p>`
+	for i, b := range d.Blocks {
+		if i > 0 {
+			body.WriteString(pageBreak)
+		}
+		switch b := b.(type) {
+		case *Table:
+			docxTable(&body, b)
+		case *Listing:
+			for j, t := range b.tables() {
+				if j > 0 {
+					body.WriteString(pageBreak)
+				}
+				docxTable(&body, t)
+			}
+		case *Figure:
+			img, err := ioutil.ReadFile(b.File)
+			if err != nil {
+				return err
+			}
+			media = append(media, img)
+			docxFigure(&body, b, len(media))
+		}
+	}
+	fmt.Fprintf(&body, `<w:sectPr><w:headerReference w:type="default" r:id="rIdHeader"/>`+
+		`<w:footerReference w:type="default" r:id="rIdFooter"/>`+
+		`<w:pgSz w:w="%d" w:h="%d" w:orient="landscape"/>`+
+		`<w:pgMar w:top="%d" w:right="%d" w:bottom="%d" w:left="%d" w:header="%d" w:footer="%d" w:gutter="0"/>`+
+		`</w:sectPr></w:body></w:document>`,
+		rtfPaperW, rtfPaperH, rtfTopMarg, rtfMargin, rtfBotMarg, rtfMargin, rtfMargin, rtfMargin)
+
+	// 	Relationships of the document to the header, footer, styles and pictures
+	var rels bytes.Buffer
+	rels.WriteString(xmlDecl + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	rels.WriteString(`<Relationship Id="rIdStyles" Type="` + relNS + `/styles" Target="styles.xml"/>`)
+	rels.WriteString(`<Relationship Id="rIdHeader" Type="` + relNS + `/header" Target="header1.xml"/>`)
+	rels.WriteString(`<Relationship Id="rIdFooter" Type="` + relNS + `/footer" Target="footer1.xml"/>`)
+	for i := range media {
+		fmt.Fprintf(&rels, `<Relationship Id="rIdImage%d" Type="%s/image" Target="media/image%d.png"/>`,
+			i+1, relNS, i+1)
+	}
+	rels.WriteString(`</Relationships>`)
+
+	// 	The package
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	type part struct {
+		name string
+		data []byte
+	}
+	parts := []part{
+		{"[Content_Types].xml", []byte(docxContentTypes)},
+		{"_rels/.rels", []byte(docxRels)},
+		{"word/document.xml", body.Bytes()},
+		{"word/_rels/document.xml.rels", rels.Bytes()},
+		{"word/styles.xml", []byte(docxStyles)},
+		{"word/header1.xml", hdr.Bytes()},
+		{"word/footer1.xml", ftr.Bytes()},
+	}
+	for i, img := range media {
+		parts = append(parts, part{fmt.Sprintf("word/media/image%d.png", i+1), img})
+	}
+	for _, p := range parts {
+		w, err := z.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(p.data); err != nil {
+			return err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+
+	// 	Output
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	_, err = fo.Write(buf.Bytes())
+	return err
+}
+
+// A table cell: its width in twips, the number of grid columns spanned, the
+// justification and the text
+type docxCell struct {
+	width int
+	span  int
+	just  string
+	text  string
+}
+
+// A table row. Header rows are repeated at the top of each page, and a row
+// to keep with the next is kept on the same page.
+func docxRow(buf *bytes.Buffer, header bool, keep bool, cells []docxCell, border string) {
+	buf.WriteString(`<w:tr><w:trPr><w:cantSplit/>`)
+	if header {
+		buf.WriteString(`<w:tblHeader/>`)
+	}
+	buf.WriteString(`</w:trPr>`)
+	for _, c := range cells {
+		fmt.Fprintf(buf, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/>`, c.width)
+		if c.span > 1 {
+			fmt.Fprintf(buf, `<w:gridSpan w:val="%d"/>`, c.span)
+		}
+		if border != "" {
+			buf.WriteString(`<w:tcBorders>`)
+			for _, side := range border {
+				name := map[rune]string{'T': "top", 'B': "bottom"}[side]
+				fmt.Fprintf(buf, `<w:%s w:val="single" w:sz="4" w:space="0" w:color="auto"/>`, name)
+			}
+			buf.WriteString(`</w:tcBorders>`)
+		}
+		buf.WriteString(`</w:tcPr>`)
+		ppr := ""
+		if keep {
+			ppr = `<w:keepNext/>`
+		}
+		buf.WriteString(docxPara(c.text, docxAlign(c.just), nil, ppr))
+		buf.WriteString(`</w:tc>`)
+	}
+	buf.WriteString(`</w:tr>`)
+}
+
+// Start a table with its grid of column widths, indented from the margin
+func docxTableStart(buf *bytes.Buffer, widths []int, indent int) {
+	fmt.Fprintf(buf, `<w:tbl><w:tblPr><w:tblW w:w="0" w:type="auto"/><w:tblInd w:w="%d" w:type="dxa"/>`+
+		`<w:tblLayout w:type="fixed"/><w:tblCellMar><w:left w:w="57" w:type="dxa"/>`+
+		`<w:right w:w="57" w:type="dxa"/></w:tblCellMar></w:tblPr><w:tblGrid>`, indent)
+	for _, w := range widths {
+		fmt.Fprintf(buf, `<w:gridCol w:w="%d"/>`, w)
+	}
+	buf.WriteString(`</w:tblGrid>`)
+}
+
+// Write a table: the caption, the spanning headers and the column headers
+// as header rows, then the rows
+func docxTable(buf *bytes.Buffer, t *Table) {
+	t = t.laidOut()
+	var widths []int
+	total := 0
+	for _, c := range t.Columns {
+		widths = append(widths, twips(c.Width))
+		total += twips(c.Width)
+	}
+	docxTableStart(buf, widths, 0)
+
+	if t.Caption != "" {
+		docxRow(buf, true, false, []docxCell{{total, len(widths), "L", t.Caption}}, "")
+	}
+	border := "TB"
+	if len(t.Spans) > 0 {
+		border = "B"
+		var cells []docxCell
+		col := 0
+		for _, s := range t.Spans {
+			for ; col < s.From; col++ {
+				cells = append(cells, docxCell{widths[col], 1, "L", ""})
+			}
+			w := 0
+			for _, cw := range widths[s.From : s.From+s.Span] {
+				w += cw
+			}
+			cells = append(cells, docxCell{w, s.Span, "C", s.Header})
+			col = s.From + s.Span
+		}
+		for ; col < len(widths); col++ {
+			cells = append(cells, docxCell{widths[col], 1, "L", ""})
+		}
+		docxRow(buf, true, false, cells, "T")
+	}
+	docxRow(buf, true, false, docxCells(t.Columns, nil, true), border)
+
+	// 	A nil row is a blank line
+	next := keepNext(t)
+	for i, row := range t.Rows {
+		docxRow(buf, false, next[i], docxCells(t.Columns, row, false), "")
+	}
+	buf.WriteString(`</w:tbl><w:p/>`)
+}
+
+// The cells of a row of a table, or of the column headers
+func docxCells(cols []Column, row []string, headers bool) []docxCell {
+	var cells []docxCell
+	for i, c := range cols {
+		text := c.Header
+		if !headers {
+			text = ""
+			if i < len(row) {
+				text = row[i]
+			}
+		}
+		cells = append(cells, docxCell{twips(c.Width), 1, c.Just, text})
+	}
+	return cells
+}
+
+// Write a figure, the n-th picture of the document, with the table and text
+// below it
+func docxFigure(buf *bytes.Buffer, f *Figure, n int) {
+	indent := twips(figureX - pageMargin)
+	cx, cy := int(f.Width*emuPerMM), int(f.Height*emuPerMM)
+	fmt.Fprintf(buf, `<w:p><w:pPr><w:ind w:left="%d"/></w:pPr><w:r><w:drawing>`+
+		`<wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%d" cy="%d"/>`+
+		`<wp:docPr id="%d" name="Figure %d"/>`+
+		`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:pic><pic:nvPicPr><pic:cNvPr id="%d" name="image%d.png"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="rIdImage%d"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm>`+
+		`<a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr></pic:pic>`+
+		`</a:graphicData></a:graphic></wp:inline></w:drawing></w:r></w:p>`,
+		indent, cx, cy, n, n, n, n, n, cx, cy)
+
+	if len(f.Columns) > 0 {
+		ft := f.table()
+		var widths []int
+		for _, c := range ft.Columns {
+			widths = append(widths, twips(c.Width))
+		}
+		docxTableStart(buf, widths, indent)
+		docxRow(buf, false, false, docxCells(ft.Columns, nil, true), "")
+		for _, row := range ft.Rows {
+			docxRow(buf, false, false, docxCells(ft.Columns, row, false), "")
+		}
+		buf.WriteString(`</w:tbl><w:p/>`)
+	}
+	for _, s := range f.Text {
+		buf.WriteString(docxPara(s, "left", nil, fmt.Sprintf(`<w:ind w:left="%d"/>`, indent)))
+	}
+}
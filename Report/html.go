@@ -0,0 +1,274 @@
+package Report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The style sheet of the HTML: the layout of the headers and footnotes as on
+// the printed page, and rules above and below the column headers
+const htmlStyle = `
+body { font-family: "Courier New", Courier, monospace; font-size: 10pt; margin: 2em; }
+.head, .foot { display: flex; justify-content: space-between; }
+.centre { text-align: center; }
+.foot { margin-top: 2em; padding-top: 0.3em; border-top: 1px solid black; flex-direction: column; }
+section { margin-top: 2em; }
+h3 { font-size: 10pt; font-weight: normal; margin: 1em 0 0.3em 0; }
+input.filter { font-family: inherit; margin-bottom: 0.3em; }
+table { border-collapse: collapse; }
+th, td { padding: 0 0.5em; white-space: pre; font-weight: normal; }
+th { white-space: normal; vertical-align: bottom; }
+thead tr:first-child th { border-top: 1px solid black; }
+thead tr:last-child th { border-bottom: 1px solid black; cursor: pointer; }
+thead tr.span th { border-bottom: 1px solid black; cursor: default; }
+thead tr.span th:empty { border-bottom: none; }
+th[data-dir="asc"]::after { content: " \25B2"; }
+th[data-dir="desc"]::after { content: " \25BC"; }
+tr.blank td { height: 1em; }
+table.figure th { border: none; cursor: default; }
+`
+
+// The script of the HTML: each data table is filtered by the text typed in
+// the box above it, and sorted by a column by clicking its header, numbers
+// as numbers. Clicks cycle through ascending, descending and the original order.
+// The rows are filtered and sorted in their blocks, each a tbody, by the
+// first row of the block; the blank lines between blocks stay in place and
+// are hidden while filtering.
+const htmlScript = `
+function compare(a, b) {
+	var x = parseFloat(a), y = parseFloat(b);
+	if (!isNaN(x) && !isNaN(y) && x !== y) {
+		return x - y;
+	}
+	return a.trim().localeCompare(b.trim());
+}
+Array.prototype.forEach.call(document.querySelectorAll("table.data"), function(t) {
+	var bodies = Array.prototype.slice.call(t.tBodies);
+	var blocks = bodies.filter(function(b) { return b.className !== "blank"; });
+	var filter = t.previousElementSibling;
+	filter.addEventListener("input", function() {
+		var q = filter.value.toLowerCase();
+		bodies.forEach(function(b) {
+			var show = b.className === "blank" ? q === "" : b.textContent.toLowerCase().indexOf(q) >= 0;
+			b.style.display = show ? "" : "none";
+		});
+	});
+	var heads = t.tHead.rows[t.tHead.rows.length - 1].cells;
+	Array.prototype.forEach.call(heads, function(th, col) {
+		th.addEventListener("click", function() {
+			var dir = {"": "asc", "asc": "desc", "desc": ""}[th.getAttribute("data-dir") || ""];
+			Array.prototype.forEach.call(heads, function(h) { h.removeAttribute("data-dir"); });
+			var sorted = blocks.slice();
+			if (dir) {
+				th.setAttribute("data-dir", dir);
+				sorted.sort(function(a, b) {
+					var c = compare(a.rows[0].cells[col].textContent, b.rows[0].cells[col].textContent);
+					return dir === "asc" ? c : -c;
+				});
+			}
+			var next = 0;
+			bodies.forEach(function(b) {
+				t.appendChild(b.className === "blank" ? b : sorted[next++]);
+			});
+		});
+	});
+});
+`
+
+// The extension of the picture files of figures for a format: SVG for HTML,
+// which is inlined in the page, and PNG for the others
+func ImageExt(format string) string {
+	if strings.ToLower(format) == FormatHTML {
+		return ".svg"
+	}
+	return ".png"
+}
+
+// The alignment of a column justification
+func htmlAlign(just string) string {
+	switch just {
+	case "C":
+		return "center"
+	case "R":
+		return "right"
+	}
+	return "left"
+}
+
+// Write the document to a single self-contained HTML file: the style, the
+// script and the pictures of the figures are all held in the file. The
+// headers are shown above the content and the footnotes below it.
+func (d *Doc) WriteHTML(outfile *string) error {
+	var buf bytes.Buffer
+	h, f := d.Headers, d.Footers
+
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n", html.EscapeString(h.Head5Centre))
+	fmt.Fprintf(&buf, "<style>%s</style>\n</head>\n<body>\n", htmlStyle)
+
+	// 	Headers: lines 1 and 2 left and right, line 3 left, lines 4 to 6 centred
+	fmt.Fprintf(&buf, "<div class=\"head\"><span>%s</span><span>%s</span></div>\n",
+		html.EscapeString(h.Head1Left), html.EscapeString(h.Head1Right))
+	fmt.Fprintf(&buf, "<div class=\"head\"><span>%s</span><span>%s</span></div>\n",
+		html.EscapeString(h.Head2Left), html.EscapeString(h.Head2Right))
+	fmt.Fprintf(&buf, "<div>%s</div>\n", html.EscapeString(h.Head3Left))
+	for _, s := range []string{h.Head4Centre, h.Head5Centre, h.Head6Centre} {
+		fmt.Fprintf(&buf, "<div class=\"centre\">%s</div>\n", html.EscapeString(s))
+	}
+
+	for _, b := range d.Blocks {
+		switch b := b.(type) {
+		case *Table:
+			htmlTable(&buf, b)
+		case *Listing:
+			for _, t := range b.tables() {
+				htmlTable(&buf, t)
+			}
+		case *Figure:
+			if err := htmlFigure(&buf, b); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 	Footnotes, and the program and run timestamp
+	buf.WriteString("<div class=\"foot\">\n")
+	for _, s := range []string{f.Foot1Left, f.Foot2Left, f.Foot3Left} {
+		fmt.Fprintf(&buf, "<div>%s</div>\n", html.EscapeString(s))
+	}
+	fmt.Fprintf(&buf, "<div class=\"head\"><span>%s</span><span>%s</span></div>\n",
+		html.EscapeString(d.Program), html.EscapeString(d.Run))
+	buf.WriteString("</div>\n")
+	fmt.Fprintf(&buf, "<script>%s</script>\n</body>\n</html>\n", htmlScript)
+
+	// 	Output
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	w := bufio.NewWriter(fo)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Write a row of cells, th or td, with the justification of the columns
+func htmlRow(buf *bytes.Buffer, class string, tag string, cells []string, cols []Column) {
+	if class != "" {
+		fmt.Fprintf(buf, "<tr class=\"%s\">", class)
+	} else {
+		buf.WriteString("<tr>")
+	}
+	for i, c := range cols {
+		var s string
+		if i < len(cells) {
+			s = cells[i]
+		}
+		fmt.Fprintf(buf, "<%s style=\"text-align:%s\">%s</%s>", tag, htmlAlign(c.Just), html.EscapeString(s), tag)
+	}
+	buf.WriteString("</tr>\n")
+}
+
+// Write a table with its caption, a box to filter the rows and the column
+// headers under any spanning headers. Each block of rows, as kept together
+// on a page, is a tbody, and each blank line a tbody of its own.
+func htmlTable(buf *bytes.Buffer, t *Table) {
+	t = t.laidOut()
+	buf.WriteString("<section>\n")
+	if t.Caption != "" {
+		fmt.Fprintf(buf, "<h3>%s</h3>\n", html.EscapeString(t.Caption))
+	}
+	buf.WriteString("<input class=\"filter\" type=\"search\" placeholder=\"Filter rows\">\n")
+	buf.WriteString("<table class=\"data\">\n<thead>\n")
+	if len(t.Spans) > 0 {
+		buf.WriteString("<tr class=\"span\">")
+		col := 0
+		for _, s := range t.Spans {
+			for ; col < s.From; col++ {
+				buf.WriteString("<th></th>")
+			}
+			fmt.Fprintf(buf, "<th colspan=\"%d\" style=\"text-align:center\">%s</th>", s.Span, html.EscapeString(s.Header))
+			col = s.From + s.Span
+		}
+		for ; col < len(t.Columns); col++ {
+			buf.WriteString("<th></th>")
+		}
+		buf.WriteString("</tr>\n")
+	}
+	var headers []string
+	for _, c := range t.Columns {
+		headers = append(headers, c.Header)
+	}
+	htmlRow(buf, "", "th", headers, t.Columns)
+	buf.WriteString("</thead>\n")
+
+	// 	A nil row is a blank line, only ever at the start of a block
+	for _, b := range blocks(t) {
+		for len(b) > 0 && len(t.Rows[b[0]]) == 0 {
+			buf.WriteString("<tbody class=\"blank\">\n")
+			htmlRow(buf, "blank", "td", nil, t.Columns)
+			buf.WriteString("</tbody>\n")
+			b = b[1:]
+		}
+		if len(b) == 0 {
+			continue
+		}
+		buf.WriteString("<tbody>\n")
+		for _, i := range b {
+			htmlRow(buf, "", "td", t.Rows[i], t.Columns)
+		}
+		buf.WriteString("</tbody>\n")
+	}
+	buf.WriteString("</table>\n</section>\n")
+}
+
+// Write a figure with the table and text below it. An SVG picture is
+// inlined, any other is embedded as PNG data.
+func htmlFigure(buf *bytes.Buffer, f *Figure) error {
+	img, err := ioutil.ReadFile(f.File)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "<section>\n<div style=\"margin-left:%gmm; width:%gmm; height:%gmm\">\n",
+		figureX-pageMargin, f.Width, f.Height)
+	if strings.ToLower(filepath.Ext(f.File)) == ".svg" {
+		// 	Drop the XML declaration and document type ahead of the svg element
+		s := string(img)
+		if i := strings.Index(s, "<svg"); i >= 0 {
+			s = s[i:]
+		}
+		buf.WriteString(s)
+	} else {
+		fmt.Fprintf(buf, "<img src=\"data:image/png;base64,%s\" style=\"width:100%%; height:100%%\" alt=\"%s\">",
+			base64.StdEncoding.EncodeToString(img), html.EscapeString(filepath.Base(f.File)))
+	}
+	buf.WriteString("\n</div>\n")
+
+	if len(f.Columns) > 0 {
+		ft := f.table()
+		fmt.Fprintf(buf, "<table class=\"figure\" style=\"margin-left:%gmm\">\n", figureX-pageMargin)
+		var headers []string
+		for _, c := range ft.Columns {
+			headers = append(headers, c.Header)
+		}
+		htmlRow(buf, "", "th", headers, ft.Columns)
+		for _, row := range ft.Rows {
+			htmlRow(buf, "", "td", row, ft.Columns)
+		}
+		buf.WriteString("</table>\n")
+	}
+	for _, s := range f.Text {
+		fmt.Fprintf(buf, "<div style=\"margin-left:%gmm\">%s</div>\n", figureX-pageMargin, html.EscapeString(s))
+	}
+	buf.WriteString("</section>\n")
+	return nil
+}
@@ -0,0 +1,288 @@
+package Report
+
+// Layout of tables, shared by the output formats: the widths of columns
+// measured from their values, the alignment of numeric values on their
+// decimal points, and, for the paged formats, the rows on each page.
+//
+// The rows are placed on pages in blocks. With Keep, a block is a row with a
+// non-blank first cell, any blank lines before it and the following rows
+// with a blank first cell, e.g. the statistics of a variable under its label;
+// a block is moved to a new page rather than split, unless it is taller
+// than a page. Without Keep each row is a block. A row that does not fit in
+// the space left on a page starts a new page, and a blank line is never the
+// first on a page.
+
+import "strings"
+
+// The suffix of the caption of a table on the pages after its first
+const continued = " (continued)"
+
+// The caption of a table on a page, with the suffix on continuation pages
+func pageCaption(t *Table, cont bool) string {
+	if cont && t.Caption != "" {
+		return t.Caption + continued
+	}
+	return t.Caption
+}
+
+// The blocks of the rows of a table, as indexes of the rows
+func blocks(t *Table) [][]int {
+	var bs [][]int
+	var b []int
+	lead := false // Only blank lines so far in the block
+	for i, row := range t.Rows {
+		blank := len(row) == 0
+		if len(b) > 0 && (!t.Keep || !lead && (blank || row[0] != "")) {
+			bs = append(bs, b)
+			b = nil
+		}
+		if len(b) == 0 {
+			lead = true
+		}
+		b = append(b, i)
+		lead = lead && blank
+	}
+	if len(b) > 0 {
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+// The rows of a table on each page, as indexes of the rows. Avail is the
+// height available for rows on a page, below the caption and column headers,
+// and height gives the height of a row in the same units.
+func paginate(t *Table, avail float64, height func(i int) float64) [][]int {
+	var pages [][]int
+	var page []int
+	used := 0.0
+	newPage := func() {
+		pages = append(pages, page)
+		page = nil
+		used = 0
+	}
+	for _, b := range blocks(t) {
+		var h float64
+		for _, i := range b {
+			h += height(i)
+		}
+		if len(page) > 0 && used+h > avail && h <= avail {
+			newPage()
+		}
+		for _, i := range b {
+			rh := height(i)
+			if len(page) > 0 && used+rh > avail {
+				newPage()
+			}
+			if len(page) == 0 && len(t.Rows[i]) == 0 {
+				continue
+			}
+			page = append(page, i)
+			used += rh
+		}
+	}
+	return append(pages, page)
+}
+
+// Whether each row of a table is kept on a page with the next row, as the
+// rows of a block but the last, for formats paged by the reader
+func keepNext(t *Table) []bool {
+	next := make([]bool, len(t.Rows))
+	for _, b := range blocks(t) {
+		for _, i := range b[:len(b)-1] {
+			next[i] = true
+		}
+	}
+	return next
+}
+
+// Measurement of text in the report font, Courier 10 point, in mm
+const (
+	charWidth  = 10 * 0.6 * 25.4 / 72 // Width of a character
+	cellMargin = 1.0                  // Space either side of the text of a cell
+	colGap     = 2 * charWidth        // Further space between columns of measured width
+	pageWidth  = 297 - 2*pageMargin   // Width of the page between the margins
+)
+
+// The width of a text in mm
+func textMM(s string) float64 {
+	return float64(len([]rune(s))) * charWidth
+}
+
+// The position of the decimal point of a value starting with a number, e.g.
+// 12.3 (4.56) or <.0001, at the end of the integer part when it has none.
+// -1 if the value does not start with a number.
+func decimalPos(s string) int {
+	i := 0
+	for i < len(s) && strings.IndexByte("<>-+", s[i]) >= 0 {
+		i++
+	}
+	start := i
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i < len(s) && s[i] == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+		return i
+	}
+	if i == start {
+		return -1
+	}
+	return i
+}
+
+// Align the values of a column on their decimal points if they are all
+// numbers, the values not estimable (NE) aligned as integers. The values are
+// padded with spaces to the same length, so that the block of values is
+// justified in the column as one. Other columns are left as they are.
+func alignColumn(values []string) []string {
+	pos := make([]int, len(values))
+	maxPos, maxAfter := 0, 0
+	numeric := false
+	for i, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		p := decimalPos(v)
+		if v == "NE" {
+			p = len(v)
+		}
+		if p < 0 {
+			return values
+		}
+		numeric = true
+		pos[i] = p
+		if p > maxPos {
+			maxPos = p
+		}
+		if len(v)-p > maxAfter {
+			maxAfter = len(v) - p
+		}
+	}
+	if !numeric {
+		return values
+	}
+
+	out := make([]string, len(values))
+	for i, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			v = strings.Repeat(" ", maxPos-pos[i]) + v
+			v += strings.Repeat(" ", maxPos+maxAfter-len(v))
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// The rows with the values of each column aligned, a nil row staying nil
+func alignRows(rows [][]string, ncol int) [][]string {
+	out := make([][]string, len(rows))
+	for i, row := range rows {
+		if row != nil {
+			out[i] = make([]string, ncol)
+		}
+	}
+	for c := 0; c < ncol; c++ {
+		values := make([]string, len(rows))
+		for i, row := range rows {
+			if c < len(row) {
+				values[i] = row[c]
+			}
+		}
+		for i, v := range alignColumn(values) {
+			if out[i] != nil {
+				out[i][c] = v
+			}
+		}
+	}
+	return out
+}
+
+// The columns with the widths of those with none (zero) measured from the
+// rows: the widest value or header. If the columns are then wider than the
+// page, headers are measured by their widest word, so that they wrap between
+// words. Spanning headers widen the columns under them as needed. When the
+// columns are still wider than the page the measured columns are narrowed
+// in proportion, their values wrapping.
+func measure(cols []Column, spans []Span, rows [][]string) []Column {
+	out := append([]Column(nil), cols...)
+	auto := make([]bool, len(cols))
+	narrow := make([]float64, len(cols))
+	var total float64
+	for i, c := range cols {
+		if c.Width > 0 {
+			total += c.Width
+			continue
+		}
+		auto[i] = true
+		var w float64
+		for _, row := range rows {
+			if i < len(row) && textMM(strings.TrimRight(row[i], " ")) > w {
+				w = textMM(strings.TrimRight(row[i], " "))
+			}
+		}
+		narrow[i] = w
+		for _, word := range strings.Fields(c.Header) {
+			if textMM(word) > narrow[i] {
+				narrow[i] = textMM(word)
+			}
+		}
+		if textMM(c.Header) > w {
+			w = textMM(c.Header)
+		}
+		out[i].Width = w + 2*cellMargin + colGap
+		narrow[i] += 2*cellMargin + colGap
+		total += out[i].Width
+	}
+	if total > pageWidth {
+		for i := range out {
+			if auto[i] {
+				out[i].Width = narrow[i]
+			}
+		}
+	}
+	for _, s := range spans {
+		var w float64
+		n := 0
+		for i := s.From; i < s.From+s.Span; i++ {
+			w += out[i].Width
+			if auto[i] {
+				n++
+			}
+		}
+		if need := textMM(s.Header) + 2*cellMargin; need > w && n > 0 {
+			for i := s.From; i < s.From+s.Span; i++ {
+				if auto[i] {
+					out[i].Width += (need - w) / float64(n)
+				}
+			}
+		}
+	}
+
+	var fixed, measured float64
+	for i, c := range out {
+		if auto[i] {
+			measured += c.Width
+		} else {
+			fixed += c.Width
+		}
+	}
+	if measured > 0 && fixed+measured > pageWidth {
+		scale := (pageWidth - fixed) / measured
+		for i := range out {
+			if auto[i] {
+				out[i].Width *= scale
+			}
+		}
+	}
+	return out
+}
+
+// The table laid out for output: the values of numeric columns aligned on
+// their decimal points and the widths of the columns measured
+func (t *Table) laidOut() *Table {
+	lt := *t
+	lt.Rows = alignRows(t.Rows, len(t.Columns))
+	lt.Columns = measure(t.Columns, t.Spans, lt.Rows)
+	return &lt
+}
@@ -0,0 +1,229 @@
+package Report
+
+import (
+	"fmt"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Page layout of the PDF, A4 landscape in mm
+const (
+	rowHeight    = 4.0  // Vertical spacing of the lines of rows of tables
+	footerHeight = 30.0 // Height of the footer block at the foot of the page
+	figureX      = 30.0 // Left position of figures
+	pageMargin   = 10.0 // Left and right margins
+)
+
+// Write the document to a PDF file
+func (d *Doc) WritePDF(outfile *string) error {
+	pdf := newPDF(func() *Doc { return d })
+	pdfBlocks(pdf, d)
+
+	// 	Output
+	return pdf.OutputFileAndClose(*outfile)
+}
+
+// A new PDF, each page with the headers and footers of the document doc()
+// at the start of the page, and numbered "Page x of y" through the file
+func newPDF(doc func() *Doc) *gofpdf.Fpdf {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	var d *Doc // The document of the current page
+
+	// 	AddPage() executes the header and footer functions
+	pdf.SetHeaderFunc(func() {
+		d = doc()
+		h := d.Headers
+		pdf.SetFont("Courier", "", 10)
+		pdf.CellFormat(0, 10, h.Head1Left, "0", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 10, h.Head1Right, "0", 0, "R", false, 0, "")
+		pdf.Ln(4)
+		pdf.CellFormat(0, 10, h.Head2Left, "0", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 10, h.Head2Right, "0", 0, "R", false, 0, "")
+		pdf.Ln(4)
+		pdf.CellFormat(0, 10, h.Head3Left, "0", 0, "L", false, 0, "")
+		pdf.Ln(4)
+		pdf.CellFormat(0, 10, h.Head4Centre, "0", 0, "C", false, 0, "")
+		pdf.Ln(4)
+		pdf.CellFormat(0, 10, h.Head5Centre, "0", 0, "C", false, 0, "")
+		pdf.Ln(4)
+		pdf.CellFormat(0, 10, h.Head6Centre, "0", 0, "C", false, 0, "")
+		pdf.Ln(10)
+	})
+
+	// 	A rule above the footnotes, and the page number as "Page x of y"
+	pdf.SetFooterFunc(func() {
+		f := d.Footers
+		pdf.SetY(-footerHeight)
+		pdf.SetFont("Courier", "", 10)
+		pdf.CellFormat(0, 10, f.Foot1Left, "T", 0, "L", false, 0, "")
+		pdf.Ln(4)
+		pdf.CellFormat(0, 10, f.Foot2Left, "0", 0, "L", false, 0, "")
+		pdf.Ln(4)
+		pdf.CellFormat(0, 10, f.Foot3Left, "0", 0, "L", false, 0, "")
+		pdf.Ln(4)
+		pdf.CellFormat(0, 10, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "L", false, 0, "")
+		pdf.SetX(40)
+		pdf.CellFormat(0, 10, d.Program, "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 10, d.Run, "", 0, "R", false, 0, "")
+	})
+	pdf.AliasNbPages("")
+	return pdf
+}
+
+// Write the blocks of a document, each starting a new page
+func pdfBlocks(pdf *gofpdf.Fpdf, d *Doc) {
+	for _, b := range d.Blocks {
+		switch b := b.(type) {
+		case *Table:
+			pdfTable(pdf, b)
+		case *Listing:
+			for _, t := range b.tables() {
+				pdfTable(pdf, t)
+			}
+		case *Figure:
+			pdfFigure(pdf, b)
+		}
+	}
+}
+
+// Start a page of a table with the caption, marked as continued after the
+// first page, and the column headers
+func pdfTableHead(pdf *gofpdf.Fpdf, t *Table, cont bool) {
+	pdf.AddPage()
+	if t.Caption != "" {
+		pdf.CellFormat(0, 8, pageCaption(t, cont), "", 0, "L", false, 0, "")
+		pdf.Ln(8)
+	}
+	border := "TB"
+	if len(t.Spans) > 0 {
+		border = "B"
+		col := 0
+		for _, s := range t.Spans {
+			for ; col < s.From; col++ {
+				pdf.CellFormat(t.Columns[col].Width, 6, "", "T", 0, "L", false, 0, "")
+			}
+			var w float64
+			for _, c := range t.Columns[s.From : s.From+s.Span] {
+				w += c.Width
+			}
+			pdf.CellFormat(w, 6, s.Header, "T", 0, "C", false, 0, "")
+			col = s.From + s.Span
+		}
+		for ; col < len(t.Columns); col++ {
+			pdf.CellFormat(t.Columns[col].Width, 6, "", "T", 0, "L", false, 0, "")
+		}
+		pdf.Ln(6)
+	}
+
+	// 	Column headers wrapped within their widths, aligned on the last line
+	var headers []string
+	for _, c := range t.Columns {
+		headers = append(headers, c.Header)
+	}
+	cells := pdfLines(pdf, t.Columns, headers)
+	n := nLines(cells)
+	h := 8 + float64(n-1)*rowHeight
+	x, y := pdf.GetX(), pdf.GetY()
+	for i, c := range t.Columns {
+		pdf.SetXY(x, y)
+		pdf.CellFormat(c.Width, h, "", border, 0, c.Just, false, 0, "")
+		for j, s := range cells[i] {
+			pdf.SetXY(x, y+float64(n-len(cells[i])+j)*rowHeight)
+			pdf.CellFormat(c.Width, 8, s, "", 0, c.Just, false, 0, "")
+		}
+		x += c.Width
+	}
+	pdf.SetY(y)
+	pdf.Ln(h)
+}
+
+// The lines of the cells of a row, each wrapped to the width of its column
+// within the cell margins
+func pdfLines(pdf *gofpdf.Fpdf, cols []Column, row []string) [][]string {
+	cells := make([][]string, len(row))
+	for i, s := range row {
+		for _, l := range pdf.SplitLines([]byte(s), cols[i].Width) {
+			cells[i] = append(cells[i], string(l))
+		}
+	}
+	return cells
+}
+
+// The number of lines of a row, at least one for a blank line
+func nLines(cells [][]string) int {
+	n := 1
+	for _, c := range cells {
+		if len(c) > n {
+			n = len(c)
+		}
+	}
+	return n
+}
+
+// Write a row, its cells on as many lines as needed
+func pdfRow(pdf *gofpdf.Fpdf, cols []Column, cells [][]string) {
+	x, y := pdf.GetX(), pdf.GetY()
+	for i, lines := range cells {
+		for j, s := range lines {
+			pdf.SetXY(x, y+float64(j)*rowHeight)
+			pdf.CellFormat(cols[i].Width, 8, s, "", 0, cols[i].Just, false, 0, "")
+		}
+		x += cols[i].Width
+	}
+	pdf.SetY(y)
+	pdf.Ln(float64(nLines(cells)) * rowHeight)
+}
+
+// Write a table on as many pages as needed, the rows laid out in the height
+// above the footer left under the column headers
+func pdfTable(pdf *gofpdf.Fpdf, t *Table) {
+	t = t.laidOut()
+
+	// 	The font for splitting lines is set with the first page
+	pdfTableHead(pdf, t, false)
+	cells := make([][][]string, len(t.Rows))
+	for i, row := range t.Rows {
+		cells[i] = pdfLines(pdf, t.Columns, row)
+	}
+	_, pageH := pdf.GetPageSize()
+	avail := pageH - footerHeight - rowHeight - pdf.GetY()
+	pages := paginate(t, avail, func(i int) float64 {
+		return float64(nLines(cells[i])) * rowHeight
+	})
+	for p, rows := range pages {
+		if p > 0 {
+			pdfTableHead(pdf, t, true)
+		}
+		for _, i := range rows {
+			pdfRow(pdf, t.Columns, cells[i])
+		}
+	}
+}
+
+// Write a figure with the table and text below it
+func pdfFigure(pdf *gofpdf.Fpdf, f *Figure) {
+	pdf.AddPage()
+	y := pdf.GetY()
+	pdf.Image(f.File, figureX, y, f.Width, f.Height, false, "", 0, "")
+	pdf.SetXY(figureX, y+f.Height+2)
+	if len(f.Columns) > 0 {
+		ft := f.table()
+		for _, c := range ft.Columns {
+			pdf.CellFormat(c.Width, 5, c.Header, "", 0, c.Just, false, 0, "")
+		}
+		pdf.Ln(5)
+		for _, row := range ft.Rows {
+			pdf.SetX(figureX)
+			for i, str := range row {
+				pdf.CellFormat(ft.Columns[i].Width, 5, str, "", 0, ft.Columns[i].Just, false, 0, "")
+			}
+			pdf.Ln(5)
+		}
+		pdf.Ln(2)
+	}
+	for _, s := range f.Text {
+		pdf.SetX(figureX)
+		pdf.CellFormat(0, 5, s, "", 0, "L", false, 0, "")
+		pdf.Ln(5)
+	}
+}
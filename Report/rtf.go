@@ -0,0 +1,244 @@
+package Report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Page layout of the RTF, A4 landscape in twips (1/20 point)
+const (
+	twipsPerMM  = 1440 / 25.4
+	rtfPaperW   = 16838 // A4 width, landscape
+	rtfPaperH   = 11906 // A4 height, landscape
+	rtfMargin   = 567   // 10 mm, as the PDF
+	rtfTopMarg  = 2268  // Space for the six header lines
+	rtfBotMarg  = 1701  // Space for the footnotes
+	rtfFontSize = 20    // Half points, i.e. 10 point
+)
+
+// A length in mm in twips
+func twips(mm float64) int {
+	return int(mm*twipsPerMM + 0.5)
+}
+
+// Escape text for RTF: the control characters \, { and }, and characters
+// outside ASCII as Unicode with ? for readers without Unicode support
+func rtfEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '{' || r == '}':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r > 127:
+			// 	\u takes a signed 16 bit value
+			if r > 0xFFFF {
+				r = '?'
+			}
+			fmt.Fprintf(&b, "\\u%d?", int16(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// The paragraph alignment of a column justification
+func rtfAlign(just string) string {
+	switch just {
+	case "C":
+		return `\qc`
+	case "R":
+		return `\qr`
+	}
+	return `\ql`
+}
+
+// Write the document to an RTF file. Tables are real table rows, the caption
+// and column headers being header rows repeated on each page by the reader,
+// and the headers and footnotes are the page header and footer.
+func (d *Doc) WriteRTF(outfile *string) error {
+	var buf bytes.Buffer
+	h, f := d.Headers, d.Footers
+	right := rtfPaperW - 2*rtfMargin
+
+	buf.WriteString(`{\rtf1\ansi\ansicpg1252\deff0` + "\n")
+	buf.WriteString(`{\fonttbl{\f0\fmodern\fcharset0 Courier New;}}` + "\n")
+	fmt.Fprintf(&buf, `\paperw%d\paperh%d\landscape\margl%d\margr%d\margt%d\margb%d\headery%d\footery%d`+"\n",
+		rtfPaperW, rtfPaperH, rtfMargin, rtfMargin, rtfTopMarg, rtfBotMarg, rtfMargin, rtfMargin)
+	fmt.Fprintf(&buf, `\f0\fs%d`+"\n", rtfFontSize)
+
+	// 	Page header: lines 1 and 2 left and right, line 3 left, lines 4 to 6 centred
+	fmt.Fprintf(&buf, `{\header\pard\plain\f0\fs%d\tqr\tx%d `, rtfFontSize, right)
+	fmt.Fprintf(&buf, `%s\tab %s\par `, rtfEscape(h.Head1Left), rtfEscape(h.Head1Right))
+	fmt.Fprintf(&buf, `%s\tab %s\par `, rtfEscape(h.Head2Left), rtfEscape(h.Head2Right))
+	fmt.Fprintf(&buf, `%s\par\qc `, rtfEscape(h.Head3Left))
+	fmt.Fprintf(&buf, `%s\par %s\par %s\par}`+"\n",
+		rtfEscape(h.Head4Centre), rtfEscape(h.Head5Centre), rtfEscape(h.Head6Centre))
+
+	// 	Page footer: a rule above the footnotes, and the page number as "Page x of y"
+	fmt.Fprintf(&buf, `{\footer\pard\plain\f0\fs%d\brdrt\brdrs\brdrw10 %s\par\pard `,
+		rtfFontSize, rtfEscape(f.Foot1Left))
+	fmt.Fprintf(&buf, `%s\par %s\par `, rtfEscape(f.Foot2Left), rtfEscape(f.Foot3Left))
+	fmt.Fprintf(&buf, `\tx%d\tqr\tx%d Page {\field{\*\fldinst PAGE}{\fldrslt 1}} of {\field{\*\fldinst NUMPAGES}{\fldrslt 1}}`,
+		twips(30), right)
+	fmt.Fprintf(&buf, `\tab %s\tab %s\par}`+"\n", rtfEscape(d.Program), rtfEscape(d.Run))
+
+	for i, b := range d.Blocks {
+		if i > 0 {
+			buf.WriteString(`\page` + "\n")
+		}
+		switch b := b.(type) {
+		case *Table:
+			rtfTable(&buf, b)
+		case *Listing:
+			for j, t := range b.tables() {
+				if j > 0 {
+					buf.WriteString(`\page` + "\n")
+				}
+				rtfTable(&buf, t)
+			}
+		case *Figure:
+			if err := rtfFigure(&buf, b); err != nil {
+				return err
+			}
+		}
+	}
+	buf.WriteString("}\n")
+
+	// 	Output
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	w := bufio.NewWriter(fo)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// A table row: the right edges of the cells with their borders, then the
+// cells. Header rows are repeated at the top of each page, and a row to keep
+// with the next is kept on the same page.
+func rtfRow(buf *bytes.Buffer, header bool, keep bool, left float64, cells []string, widths []float64, just []string, border string) {
+	fmt.Fprintf(buf, `\trowd\trgaph57\trleft%d`, twips(left))
+	if header {
+		buf.WriteString(`\trhdr`)
+	}
+	x := left
+	for _, w := range widths {
+		x += w
+		for _, side := range border {
+			fmt.Fprintf(buf, `\clbrdr%s\brdrs\brdrw10`, strings.ToLower(string(side)))
+		}
+		fmt.Fprintf(buf, `\cellx%d`, twips(x))
+	}
+	buf.WriteString("\n")
+	keepn := ""
+	if keep {
+		keepn = `\keepn`
+	}
+	for i, c := range cells {
+		fmt.Fprintf(buf, `\pard\intbl%s%s %s\cell`, keepn, rtfAlign(just[i]), rtfEscape(c))
+	}
+	buf.WriteString(`\row` + "\n")
+}
+
+// Write a table: the caption, the spanning headers and the column headers
+// as header rows, then the rows
+func rtfTable(buf *bytes.Buffer, t *Table) {
+	t = t.laidOut()
+	var widths []float64
+	var just []string
+	var total float64
+	for _, c := range t.Columns {
+		widths = append(widths, c.Width)
+		just = append(just, c.Just)
+		total += c.Width
+	}
+
+	if t.Caption != "" {
+		rtfRow(buf, true, false, 0, []string{t.Caption}, []float64{total}, []string{"L"}, "")
+	}
+	border := "TB"
+	if len(t.Spans) > 0 {
+		border = "B"
+		var cells, sj []string
+		var sw []float64
+		col := 0
+		for _, s := range t.Spans {
+			for ; col < s.From; col++ {
+				cells, sw, sj = append(cells, ""), append(sw, widths[col]), append(sj, "L")
+			}
+			var w float64
+			for _, c := range widths[s.From : s.From+s.Span] {
+				w += c
+			}
+			cells, sw, sj = append(cells, s.Header), append(sw, w), append(sj, "C")
+			col = s.From + s.Span
+		}
+		for ; col < len(widths); col++ {
+			cells, sw, sj = append(cells, ""), append(sw, widths[col]), append(sj, "L")
+		}
+		rtfRow(buf, true, false, 0, cells, sw, sj, "T")
+	}
+	var headers []string
+	for _, c := range t.Columns {
+		headers = append(headers, c.Header)
+	}
+	rtfRow(buf, true, false, 0, headers, widths, just, border)
+
+	// 	A nil row is a blank line
+	blank := make([]string, len(t.Columns))
+	next := keepNext(t)
+	for i, row := range t.Rows {
+		if row == nil {
+			row = blank
+		}
+		rtfRow(buf, false, next[i], 0, row, widths, just, "")
+	}
+	buf.WriteString(`\pard\par` + "\n")
+}
+
+// Write a figure, the PNG file embedded in the document, with the table
+// and text below it
+func rtfFigure(buf *bytes.Buffer, f *Figure) error {
+	img, err := ioutil.ReadFile(f.File)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, `\pard\li%d{\pict\pngblip\picwgoal%d\pichgoal%d`+"\n",
+		twips(figureX-pageMargin), twips(f.Width), twips(f.Height))
+	h := hex.EncodeToString(img)
+	for len(h) > 128 {
+		buf.WriteString(h[:128] + "\n")
+		h = h[128:]
+	}
+	buf.WriteString(h + "}\\par\n")
+
+	if len(f.Columns) > 0 {
+		ft := f.table()
+		var widths []float64
+		var just, headers []string
+		for _, c := range ft.Columns {
+			widths = append(widths, c.Width)
+			just = append(just, c.Just)
+			headers = append(headers, c.Header)
+		}
+		rtfRow(buf, false, false, figureX-pageMargin, headers, widths, just, "")
+		for _, row := range ft.Rows {
+			rtfRow(buf, false, false, figureX-pageMargin, row, widths, just, "")
+		}
+	}
+	for _, s := range f.Text {
+		fmt.Fprintf(buf, `\pard\li%d %s\par`+"\n", twips(figureX-pageMargin), rtfEscape(s))
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package Report
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Page layout of the text, the classic 132 column listing page. Widths in
+// mm are in characters of the report font, as measured for the PDF.
+const (
+	textWidth     = 132
+	textPageLines = 46
+)
+
+// A width in mm in characters
+func chars(mm float64) int {
+	return int(mm/charWidth + 0.5)
+}
+
+// A string padded or cut to a width, justified L, C or R
+func justify(s string, w int, just string) string {
+	n := len([]rune(s))
+	if n >= w {
+		return string([]rune(s)[:w])
+	}
+	switch just {
+	case "R":
+		return strings.Repeat(" ", w-n) + s
+	case "C":
+		l := (w - n) / 2
+		return strings.Repeat(" ", l) + s + strings.Repeat(" ", w-n-l)
+	}
+	return s + strings.Repeat(" ", w-n)
+}
+
+// A line with a left and a right part
+func leftRight(l string, r string, w int) string {
+	gap := w - len([]rune(l)) - len([]rune(r))
+	if gap < 1 {
+		gap = 1
+	}
+	return l + strings.Repeat(" ", gap) + r
+}
+
+// Wrap text at spaces into lines of at most a width, breaking words longer
+// than the width. Blank text is a single blank line.
+func wrap(s string, w int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for len([]rune(word)) > w {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, string([]rune(word)[:w]))
+			word = string([]rune(word)[w:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= w:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	return append(lines, line)
+}
+
+// The lines of the cells of a row in columns of widths, with a space between
+// columns, the cells wrapped onto further lines as needed. Bottom aligns the
+// cells on their last line, as for column headers.
+func textRow(cells []string, widths []int, just []string, indent int, bottom bool) []string {
+	var wrapped [][]string
+	n := 1
+	for i, w := range widths {
+		var s string
+		if i < len(cells) {
+			s = cells[i]
+		}
+		// 	Aligned values keep their leading spaces
+		lines := []string{s}
+		if len([]rune(strings.TrimRight(s, " "))) > w {
+			lines = wrap(s, w)
+		}
+		wrapped = append(wrapped, lines)
+		if len(lines) > n {
+			n = len(lines)
+		}
+	}
+	out := make([]string, n)
+	for l := range out {
+		line := strings.Repeat(" ", indent)
+		for i, w := range widths {
+			k := l
+			if bottom {
+				k = l - (n - len(wrapped[i]))
+			}
+			s := ""
+			if k >= 0 && k < len(wrapped[i]) {
+				s = wrapped[i][k]
+			}
+			line += justify(s, w, just[i]) + " "
+		}
+		out[l] = strings.TrimRight(line, " ")
+	}
+	return out
+}
+
+// The column widths in characters, the margins of the cells making a space
+// between the columns, the justifications and the headers of columns
+func textColumns(cols []Column) (widths []int, just []string, headers []string) {
+	for _, c := range cols {
+		widths = append(widths, chars(c.Width-2*cellMargin))
+		just = append(just, c.Just)
+		headers = append(headers, c.Header)
+	}
+	return widths, just, headers
+}
+
+// The pages of a document as text, each block starting a new page, and the
+// number of lines of the body of a page
+type textPages struct {
+	pages [][]string
+	body  int
+}
+
+// Start a new page with its first lines, e.g. the headers of a table
+func (p *textPages) newPage(head []string) {
+	p.pages = append(p.pages, append([]string(nil), head...))
+}
+
+// Add lines to the last page, starting a new page if they do not fit. A
+// blank line that does not fit is dropped rather than starting a page.
+func (p *textPages) add(lines []string) {
+	last := len(p.pages) - 1
+	if len(p.pages[last])+len(lines) > p.body {
+		if len(lines) == 1 && lines[0] == "" {
+			return
+		}
+		p.newPage(nil)
+		last++
+	}
+	p.pages[last] = append(p.pages[last], lines...)
+}
+
+// The rule under column headers
+var textRule = strings.Repeat("-", textWidth)
+
+// The caption, marked as continued after the first page, and the column
+// headers of a table
+func textHead(t *Table, widths []int, just []string, headers []string, cont bool) []string {
+	var head []string
+	if t.Caption != "" {
+		head = append(head, pageCaption(t, cont), "")
+	}
+	head = append(head, textRule)
+
+	// 	Headers spanning columns, with a rule under them
+	if len(t.Spans) > 0 {
+		spanLine := ""
+		ruleLine := ""
+		col := 0
+		for _, s := range t.Spans {
+			for ; col < s.From; col++ {
+				spanLine += strings.Repeat(" ", widths[col]+1)
+				ruleLine += strings.Repeat(" ", widths[col]+1)
+			}
+			w := -1
+			for _, cw := range widths[s.From : s.From+s.Span] {
+				w += cw + 1
+			}
+			spanLine += justify(s.Header, w, "C") + " "
+			ruleLine += strings.Repeat("-", w) + " "
+			col = s.From + s.Span
+		}
+		head = append(head, strings.TrimRight(spanLine, " "), strings.TrimRight(ruleLine, " "))
+	}
+	head = append(head, textRow(headers, widths, just, 0, true)...)
+	return append(head, textRule)
+}
+
+// Add a table, on new pages
+func (p *textPages) table(t *Table) {
+	t = t.laidOut()
+	widths, just, headers := textColumns(t.Columns)
+	var lines [][]string
+	for _, row := range t.Rows {
+		if row == nil {
+			lines = append(lines, []string{""})
+		} else {
+			lines = append(lines, textRow(row, widths, just, 0, false))
+		}
+	}
+
+	head := textHead(t, widths, just, headers, false)
+	pages := paginate(t, float64(p.body-len(head)), func(i int) float64 {
+		return float64(len(lines[i]))
+	})
+	for k, rows := range pages {
+		p.newPage(textHead(t, widths, just, headers, k > 0))
+		for _, i := range rows {
+			p.pages[len(p.pages)-1] = append(p.pages[len(p.pages)-1], lines[i]...)
+		}
+	}
+}
+
+// Add a figure: as the picture cannot be shown, a reference to its file,
+// then the table and text below it
+func (p *textPages) figure(f *Figure) {
+	p.newPage(nil)
+	p.add([]string{"Figure: see " + filepath.Base(f.File), ""})
+	indent := chars(figureX - pageMargin)
+	if len(f.Columns) > 0 {
+		ft := f.table()
+		widths, just, headers := textColumns(ft.Columns)
+		p.add(textRow(headers, widths, just, indent, true))
+		for _, row := range ft.Rows {
+			p.add(textRow(row, widths, just, indent, false))
+		}
+		p.add([]string{""})
+	}
+	for _, s := range f.Text {
+		for _, l := range wrap(s, textWidth-indent) {
+			p.add([]string{strings.Repeat(" ", indent) + l})
+		}
+	}
+}
+
+// Write the document to a plain text file of 132 column pages separated by
+// form feeds, with the headers and footnotes on each page and the values of
+// numeric columns aligned on their decimal points, so that outputs can be
+// compared with diff. The run time is not shown.
+func (d *Doc) WriteText(outfile *string) error {
+	h, f := d.Headers, d.Footers
+
+	// 	Headers: lines 1 and 2 left and right, line 3 left, lines 4 to 6 centred
+	header := []string{
+		leftRight(h.Head1Left, h.Head1Right, textWidth),
+		leftRight(h.Head2Left, h.Head2Right, textWidth),
+		h.Head3Left,
+	}
+	for _, s := range []string{h.Head4Centre, h.Head5Centre, h.Head6Centre} {
+		header = append(header, strings.TrimRight(justify(s, textWidth, "C"), " "))
+	}
+	header = append(header, "")
+
+	// 	Footnotes under a rule, then the page number line
+	footer := []string{textRule}
+	for _, s := range []string{f.Foot1Left, f.Foot2Left, f.Foot3Left} {
+		footer = append(footer, wrap(s, textWidth)...)
+	}
+
+	p := &textPages{body: textPageLines - len(header) - len(footer) - 1}
+	for _, b := range d.Blocks {
+		switch b := b.(type) {
+		case *Table:
+			p.table(b)
+		case *Listing:
+			for _, t := range b.tables() {
+				p.table(t)
+			}
+		case *Figure:
+			p.figure(b)
+		}
+	}
+
+	// 	Output, the footnotes at the foot of each page
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	w := bufio.NewWriter(fo)
+	for i, page := range p.pages {
+		if i > 0 {
+			w.WriteString("\f")
+		}
+		lines := append(append([]string(nil), header...), page...)
+		for len(lines) < textPageLines-len(footer)-1 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, footer...)
+		// 		The program by its file name and no run time, the same wherever
+		// 		and whenever it was run
+		pageNo := fmt.Sprintf("Page %d of %d", i+1, len(p.pages))
+		lines = append(lines, justify(pageNo, chars(40-pageMargin), "L")+filepath.Base(d.Program))
+		for _, l := range lines {
+			if _, err := w.WriteString(l + "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
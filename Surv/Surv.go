@@ -0,0 +1,211 @@
+// Survival analysis of time-to-event data.
+//
+// - Kaplan-Meier (product-limit) estimates of the survival function, with
+//   Greenwood standard errors and confidence limits on the log(-log) scale,
+//   as given by default by SAS PROC LIFETEST.
+// - The median survival time with the Brookmeyer-Crowley confidence interval
+//   derived from the same limits.
+// - The log-rank test of the equality of the survival functions of groups.
+package Surv
+
+import (
+	"math"
+	"sort"
+
+	"github.com/phil0lucas/GoForCP/CPStats"
+)
+
+// A single observation: the time to the event or censoring, and the censor
+// value as in ADTTE.CNSR (0=event, 1=censored).
+type Obs struct {
+	Time float64
+	Cnsr int
+}
+
+// The estimate at a distinct time with an event or censoring
+type Point struct {
+	Time    float64
+	NRisk   int     // Number at risk just before Time
+	NEvent  int     // Number of events at Time
+	NCensor int     // Number censored at Time
+	Surv    float64 // Survival estimate just after Time
+	SE      float64 // Greenwood standard error of Surv
+	Lower   float64 // Lower confidence limit of Surv
+	Upper   float64 // Upper confidence limit of Surv
+}
+
+// A Kaplan-Meier curve. Points starts at time 0 with a survival of 1.
+type Curve struct {
+	N      int
+	Events int
+	Alpha  float64
+	Points []Point
+}
+
+// Kaplan-Meier estimate of the survival function with 100(1-alpha)%
+// pointwise confidence limits
+func KM(obs []Obs, alpha float64) *Curve {
+	o := append([]Obs(nil), obs...)
+	sort.Slice(o, func(i, j int) bool { return o[i].Time < o[j].Time })
+
+	z := CPStats.NormalQuantile(1 - alpha/2)
+	c := &Curve{N: len(o), Alpha: alpha}
+	c.Points = append(c.Points, Point{NRisk: len(o), Surv: 1, Lower: 1, Upper: 1})
+
+	s, gw := 1.0, 0.0
+	nrisk := len(o)
+	for i := 0; i < len(o); {
+		t := o[i].Time
+		d, cn := 0, 0
+		for ; i < len(o) && o[i].Time == t; i++ {
+			if o[i].Cnsr == 0 {
+				d++
+			} else {
+				cn++
+			}
+		}
+		if d > 0 {
+			s *= 1 - float64(d)/float64(nrisk)
+			if nrisk > d {
+				gw += float64(d) / (float64(nrisk) * float64(nrisk-d))
+			}
+		}
+		p := Point{Time: t, NRisk: nrisk, NEvent: d, NCensor: cn, Surv: s}
+		p.SE = s * math.Sqrt(gw)
+		p.Lower, p.Upper = loglogCI(s, gw, z)
+		c.Points = append(c.Points, p)
+		c.Events += d
+		nrisk -= d + cn
+	}
+	return c
+}
+
+// Confidence limits of S on the log(-log) scale, given the Greenwood sum
+func loglogCI(s, gw, z float64) (float64, float64) {
+	if s <= 0 || s >= 1 {
+		return s, s
+	}
+	sigma := math.Sqrt(gw) / math.Abs(math.Log(s))
+	return math.Pow(s, math.Exp(z*sigma)), math.Pow(s, math.Exp(-z*sigma))
+}
+
+// The survival estimate at time t
+func (c *Curve) At(t float64) float64 {
+	s := 1.0
+	for _, p := range c.Points {
+		if p.Time > t {
+			break
+		}
+		s = p.Surv
+	}
+	return s
+}
+
+// The number of subjects at risk at time t
+func (c *Curve) AtRisk(t float64) int {
+	for _, p := range c.Points[1:] {
+		if p.Time >= t {
+			return p.NRisk
+		}
+	}
+	return 0
+}
+
+// The first time at which a survival value is at or below 0.5, or nil
+func firstBelowHalf(pts []Point, v func(Point) float64) *float64 {
+	for _, p := range pts {
+		if v(p) <= 0.5 {
+			t := p.Time
+			return &t
+		}
+	}
+	return nil
+}
+
+// The median survival time with its confidence interval.
+// Values that are not reached are nil.
+func (c *Curve) Median() (est, lower, upper *float64) {
+	est = firstBelowHalf(c.Points, func(p Point) float64 { return p.Surv })
+	lower = firstBelowHalf(c.Points, func(p Point) float64 { return p.Lower })
+	upper = firstBelowHalf(c.Points, func(p Point) float64 { return p.Upper })
+	return est, lower, upper
+}
+
+// Log-rank test of the equality of survival across groups.
+// Returns the chi-square statistic, its degrees of freedom and the p-value.
+func LogRank(groups map[string][]Obs) (float64, int, float64) {
+	var names []string
+	for g := range groups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	k := len(names)
+	if k < 2 {
+		return 0, 0, 1
+	}
+
+	// Distinct event times over all groups
+	tset := make(map[float64]bool)
+	for _, g := range names {
+		for _, o := range groups[g] {
+			if o.Cnsr == 0 {
+				tset[o.Time] = true
+			}
+		}
+	}
+	var times []float64
+	for t := range tset {
+		times = append(times, t)
+	}
+	sort.Float64s(times)
+
+	// Observed minus expected events and their covariance
+	oe := make([]float64, k)
+	v := CPStats.NewMatrix(k, k)
+	for _, t := range times {
+		n := make([]float64, k)
+		d := make([]float64, k)
+		var nt, dt float64
+		for i, g := range names {
+			for _, o := range groups[g] {
+				if o.Time >= t {
+					n[i]++
+				}
+				if o.Time == t && o.Cnsr == 0 {
+					d[i]++
+				}
+			}
+			nt += n[i]
+			dt += d[i]
+		}
+		if nt < 2 {
+			for i := range names {
+				oe[i] += d[i] - dt*n[i]/nt
+			}
+			continue
+		}
+		f := dt * (nt - dt) / (nt - 1)
+		for i := range names {
+			oe[i] += d[i] - dt*n[i]/nt
+			for j := range names {
+				if i == j {
+					v[i][j] += f * n[i] / nt * (1 - n[i]/nt)
+				} else {
+					v[i][j] -= f * n[i] * n[j] / (nt * nt)
+				}
+			}
+		}
+	}
+
+	// Drop the last group, as the differences sum to zero
+	vr := CPStats.NewMatrix(k-1, k-1)
+	for i := 0; i < k-1; i++ {
+		copy(vr[i], v[i][:k-1])
+	}
+	inv, err := CPStats.Inverse(vr)
+	if err != nil {
+		return 0, k - 1, 1
+	}
+	chisq := CPStats.QuadForm(oe[:k-1], inv, oe[:k-1])
+	return chisq, k - 1, CPStats.ChiSqP(chisq, float64(k-1))
+}
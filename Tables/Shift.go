@@ -0,0 +1,127 @@
+package Tables
+
+import "strconv"
+
+// Labels of the missing category and the totals of a shift table
+const (
+	Missing = "Missing"
+	Total   = "Total"
+)
+
+// The declaration of a shift table of a category variable, such as the
+// normal range indicator ANRIND of ADVS (or ADLB), from baseline to the
+// worst post-baseline category.
+// By, Columns, Where and Subject are as for Spec, each of the Columns being
+// shown as a block of lines. Base selects the baseline record of a subject
+// and Post the post-baseline records. Categories are in display order;
+// Worst lists them from the least to the most severe (by default in
+// display order) and the worst post-baseline category is the most severe
+// found. Percentages of the column N are to Dec decimal places.
+type ShiftSpec struct {
+	By         string
+	Columns    []string
+	Where      func(Row) bool
+	Subject    string
+	Var        string
+	Base       func(Row) bool
+	Post       func(Row) bool
+	Categories []string
+	Worst      []string
+	Dec        int
+}
+
+// A subject's column and categories
+type shiftSubj struct {
+	col  int
+	base string
+	post string
+}
+
+// Count the subjects by baseline and worst post-baseline category and lay
+// them out with a line per baseline category within each column of the spec.
+// The value columns of the table are the post-baseline categories; subjects
+// with no baseline or no post-baseline value are counted as Missing.
+func Shift(spec *ShiftSpec, rows []Row) *Table {
+	subject := spec.Subject
+	if subject == "" {
+		subject = "USUBJID"
+	}
+	worst := spec.Worst
+	if len(worst) == 0 {
+		worst = spec.Categories
+	}
+	rank := make(map[string]int)
+	for i, c := range worst {
+		rank[c] = i
+	}
+
+	// Baseline and worst post-baseline category of each subject
+	subjs := make(map[string]*shiftSubj)
+	for _, r := range rows {
+		if spec.Where != nil && !spec.Where(r) {
+			continue
+		}
+		col := -1
+		for i, c := range spec.Columns {
+			if r.Get(spec.By) == c {
+				col = i
+			}
+		}
+		if col < 0 {
+			continue
+		}
+		id := r.Get(subject)
+		s, ok := subjs[id]
+		if !ok {
+			s = &shiftSubj{col: col}
+			subjs[id] = s
+		}
+		c := r.Get(spec.Var)
+		if c == "" {
+			continue
+		}
+		if spec.Base(r) {
+			s.base = c
+		}
+		if spec.Post(r) && (s.post == "" || rank[c] > rank[s.post]) {
+			s.post = c
+		}
+	}
+
+	// Counts by column, baseline and post-baseline category
+	type key struct {
+		col        int
+		base, post string
+	}
+	counts := make(map[key]int)
+	t := &Table{N: make([]int, len(spec.Columns))}
+	for _, s := range subjs {
+		b, p := s.base, s.post
+		if b == "" {
+			b = Missing
+		}
+		if p == "" {
+			p = Missing
+		}
+		for _, k := range []key{{s.col, b, p}, {s.col, b, Total}, {s.col, Total, p}, {s.col, Total, Total}} {
+			counts[k]++
+		}
+		t.N[s.col]++
+	}
+
+	cats := append(append([]string(nil), spec.Categories...), Missing)
+	t.Columns = append(append([]string(nil), cats...), Total)
+	for i, c := range spec.Columns {
+		for j, b := range t.Columns {
+			l := Line{Stat: b}
+			if j == 0 {
+				l.Label = c + " (N=" + strconv.Itoa(t.N[i]) + ")"
+			}
+			for _, p := range t.Columns {
+				l.Values = append(l.Values, countPct(counts[key{i, b, p}], t.N[i], spec.Dec))
+			}
+			t.Lines = append(t.Lines, l)
+		}
+	}
+	return t
+}
@@ -0,0 +1,402 @@
+// Summary tables of any data set.
+//
+// A table is declared by a Spec: the column (by) variable and the values
+// shown as columns, an optional total column, the population, and the
+// continuous and categorical variables with their statistics and formats.
+// Summarize computes the statistics from records of any data set that
+// implements Row (e.g. DM, ADSL or ADVS) and lays them out as lines of
+// display strings, ready to be written by a report program.
+package Tables
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/phil0lucas/GoForCP/CPStats"
+	"github.com/phil0lucas/GoForCP/CPUtils"
+)
+
+// A record of a data set. Get returns the value of a variable, by its
+// upper case name, in character form; blank when missing.
+type Row interface {
+	Get(name string) string
+}
+
+// Statistics of a continuous variable. The SD is the sample SD; the median
+// and quartiles follow the SAS quantile definition of the Spec.
+const (
+	N       = "N"
+	Mean    = "MEAN"
+	SD      = "SD"
+	MeanSD  = "MEANSD"
+	Median  = "MEDIAN"
+	Min     = "MIN"
+	Max     = "MAX"
+	MinMax  = "MINMAX"
+	Q1      = "Q1"
+	Q3      = "Q3"
+	Q1Q3    = "Q1Q3"
+	IQR     = "IQR"
+	LCLM    = "LCLM"
+	UCLM    = "UCLM"
+	CLM     = "CLM"
+	CV      = "CV"
+	GeoMean = "GEOMEAN"
+	GeoCV   = "GEOCV"
+)
+
+// The row labels of the statistics
+var StatLabel = map[string]string{
+	N:       "Number of Non-Missing",
+	Mean:    "Mean",
+	SD:      "SD",
+	MeanSD:  "Mean (SD)",
+	Median:  "Median",
+	Min:     "Minimum",
+	Max:     "Maximum",
+	MinMax:  "Min, Max",
+	Q1:      "Q1",
+	Q3:      "Q3",
+	Q1Q3:    "Q1, Q3",
+	IQR:     "IQR",
+	LCLM:    "95% Lower CL of Mean",
+	UCLM:    "95% Upper CL of Mean",
+	CLM:     "95% CI of Mean",
+	CV:      "CV%",
+	GeoMean: "Geometric Mean",
+	GeoCV:   "Geometric CV%",
+}
+
+// The statistics shown when a continuous variable does not list any
+var DefaultStats = []string{N, MeanSD, Median, Min, Max}
+
+// Statistics shown as two parts, and their layout
+var pairs = map[string][2]string{
+	MeanSD: {Mean, SD},
+	MinMax: {Min, Max},
+	Q1Q3:   {Q1, Q3},
+	CLM:    {LCLM, UCLM},
+}
+
+var pairFmt = map[string]string{
+	MeanSD: "%s (%s)",
+	MinMax: "%s, %s",
+	Q1Q3:   "%s, %s",
+	CLM:    "(%s, %s)",
+}
+
+// Decimal places of the statistics in addition to those of the data,
+// and of the percentages, whatever the data
+var statDec = map[string]int{Mean: 1, SD: 2, LCLM: 1, UCLM: 1, GeoMean: 1}
+var pctDec = map[string]int{CV: 1, GeoCV: 1}
+
+// Confidence level of the CI of the mean
+const alpha = 0.05
+
+// Codes of the tests shown against the p-values
+var TestCode = map[string]string{
+	CPStats.TTest:    "T",
+	CPStats.Wilcoxon: "W",
+	CPStats.ChiSq:    "C",
+	CPStats.Fisher:   "F",
+}
+
+// A variable to summarize.
+// A continuous variable is shown with its statistics to Dec decimal places
+// (the mean with one more and the SD with two more). A categorical variable
+// is shown as the count and percentage of the column N of each of its
+// values, the percentage to Dec decimal places. Values gives the categories
+// in display order (by default the sorted values found) and Format their
+// display text. Where, if given, selects the records of the variable.
+type Var struct {
+	Name   string
+	Label  string
+	Cat    bool
+	Stats  []string
+	Dec    int
+	Values []string
+	Format map[string]string
+	Where  func(Row) bool
+}
+
+// The declaration of a table.
+// By is the column variable and Columns its values in display order; Total,
+// if not blank, is the heading of a column of all records. Where selects
+// the population; the column N are the numbers of distinct Subject values
+// (USUBJID by default). NLabel, if not blank, is the label of a first line
+// showing the column N. Test, if not blank, is the approach (CPStats
+// Parametric or NonParametric) to the choice of test comparing the second
+// column with the first; p-values need exactly two columns. QntlDef is the
+// SAS quantile definition (1-5) of the median and quartiles, 5 by default.
+type Spec struct {
+	By      string
+	Columns []string
+	Total   string
+	Where   func(Row) bool
+	Subject string
+	NLabel  string
+	Test    string
+	QntlDef int
+	Vars    []Var
+}
+
+// A line of a table. Label is only set on the first line of a variable,
+// as is the p-value.
+type Line struct {
+	Label  string
+	Stat   string
+	Values []string
+	P      string
+}
+
+// A summary table. Columns are the headings of the value columns, N the
+// number of subjects in each (in each block of lines for a shift table).
+type Table struct {
+	Columns []string
+	N       []int
+	Lines   []Line
+	Tests   bool
+}
+
+// Format a number to a number of decimal places
+func num(v float64, dec int) string {
+	return strconv.FormatFloat(v, 'f', dec, 64)
+}
+
+// Format a count with its percentage of a total to dec decimal places
+func countPct(n int, total int, dec int) string {
+	var pct float64
+	if total > 0 {
+		pct = float64(n) / float64(total) * 100
+	}
+	return fmt.Sprintf("%d (%s%%)", n, num(pct, dec))
+}
+
+// A statistic of the values of a column, NaN when not defined
+func stat(name string, x []float64, def int) float64 {
+	switch name {
+	case Mean:
+		return CPStats.Mean(x)
+	case SD:
+		return CPStats.SD(x)
+	case Median:
+		return CPStats.Median(x, def)
+	case Min:
+		min, _ := CPStats.MinMax(x)
+		return min
+	case Max:
+		_, max := CPStats.MinMax(x)
+		return max
+	case Q1:
+		return CPStats.Quantile(x, 0.25, def)
+	case Q3:
+		return CPStats.Quantile(x, 0.75, def)
+	case IQR:
+		return CPStats.IQR(x, def)
+	case LCLM:
+		l, _ := CPStats.MeanCI(x, alpha)
+		return l
+	case UCLM:
+		_, u := CPStats.MeanCI(x, alpha)
+		return u
+	case CV:
+		return CPStats.CV(x)
+	case GeoMean:
+		return CPStats.GeoMean(x)
+	case GeoCV:
+		return CPStats.GeoCV(x)
+	}
+	return math.NaN()
+}
+
+// The display values of a statistic over the columns. Blank for a column
+// without values, NE where the statistic is not defined. Values are not
+// padded; the report aligns them on their decimal points.
+func statLine(name string, data [][]float64, dec int, def int) []string {
+	part := func(s string) []string {
+		v := make([]string, len(data))
+		for i, x := range data {
+			if len(x) == 0 {
+				continue
+			}
+			d := dec + statDec[s]
+			if pd, ok := pctDec[s]; ok {
+				d = pd
+			}
+			if r := stat(s, x, def); math.IsNaN(r) {
+				v[i] = "NE"
+			} else {
+				v[i] = num(r, d)
+			}
+		}
+		return v
+	}
+	out := make([]string, len(data))
+	if name == N {
+		for i, x := range data {
+			out[i] = strconv.Itoa(len(x))
+		}
+		return out
+	}
+	if p, ok := pairs[name]; ok {
+		a, b := part(p[0]), part(p[1])
+		for i, x := range data {
+			if len(x) > 0 {
+				out[i] = fmt.Sprintf(pairFmt[name], a[i], b[i])
+			}
+		}
+		return out
+	}
+	return part(name)
+}
+
+// Format a test result as the p-value followed by the test code.
+// NE when the test cannot be done.
+func fmtTest(r CPStats.TestResult, err error) string {
+	if err != nil {
+		return "NE"
+	}
+	return CPStats.FormatP(r.P) + " " + TestCode[r.Test]
+}
+
+// Compute and lay out a summary table of the records
+func Summarize(spec *Spec, rows []Row) *Table {
+	cols := append([]string(nil), spec.Columns...)
+	if spec.Total != "" {
+		cols = append(cols, spec.Total)
+	}
+	subject := spec.Subject
+	if subject == "" {
+		subject = "USUBJID"
+	}
+	t := &Table{Columns: cols, N: make([]int, len(cols)),
+		Tests: spec.Test != "" && len(spec.Columns) == 2}
+
+	// The records of the population in each column
+	byCol := make([][]Row, len(cols))
+	for _, r := range rows {
+		if spec.Where != nil && !spec.Where(r) {
+			continue
+		}
+		by := r.Get(spec.By)
+		for i, c := range spec.Columns {
+			if by == c {
+				byCol[i] = append(byCol[i], r)
+				if spec.Total != "" {
+					byCol[len(cols)-1] = append(byCol[len(cols)-1], r)
+				}
+			}
+		}
+	}
+	for i, recs := range byCol {
+		seen := make(map[string]bool)
+		for _, r := range recs {
+			seen[r.Get(subject)] = true
+		}
+		t.N[i] = len(seen)
+	}
+	if spec.NLabel != "" {
+		n := make([]string, len(cols))
+		for i, v := range t.N {
+			n[i] = strconv.Itoa(v)
+		}
+		t.Lines = append(t.Lines, Line{Label: spec.NLabel, Stat: "N", Values: n})
+	}
+
+	for _, v := range spec.Vars {
+		var lines []Line
+		var p string
+		if v.Cat {
+			lines, p = catLines(spec, t, v, byCol)
+		} else {
+			lines, p = contLines(spec, t, v, byCol)
+		}
+		if len(lines) > 0 {
+			lines[0].Label = v.Label
+			lines[0].P = p
+		}
+		t.Lines = append(t.Lines, lines...)
+	}
+	return t
+}
+
+// The lines of a continuous variable and the p-value comparing the columns
+func contLines(spec *Spec, t *Table, v Var, byCol [][]Row) ([]Line, string) {
+	data := make([][]float64, len(byCol))
+	for i, recs := range byCol {
+		for _, r := range recs {
+			if v.Where != nil && !v.Where(r) {
+				continue
+			}
+			if x, err := strconv.ParseFloat(r.Get(v.Name), 64); err == nil {
+				data[i] = append(data[i], x)
+			}
+		}
+	}
+	s := v.Stats
+	if len(s) == 0 {
+		s = DefaultStats
+	}
+	def := spec.QntlDef
+	if def == 0 {
+		def = CPStats.DefaultQntlDef
+	}
+	var lines []Line
+	for _, name := range s {
+		lines = append(lines, Line{Stat: StatLabel[name], Values: statLine(name, data, v.Dec, def)})
+	}
+	var p string
+	if t.Tests {
+		p = fmtTest(CPStats.RunCont(CPStats.ContTest(spec.Test), data[1], data[0]))
+	}
+	return lines, p
+}
+
+// The lines of a categorical variable and the p-value comparing the columns
+func catLines(spec *Spec, t *Table, v Var, byCol [][]Row) ([]Line, string) {
+	counts := make([]map[string]int, len(byCol))
+	var found []string
+	for i, recs := range byCol {
+		counts[i] = make(map[string]int)
+		for _, r := range recs {
+			if v.Where != nil && !v.Where(r) {
+				continue
+			}
+			if x := r.Get(v.Name); x != "" {
+				if !CPUtils.StringInSlice(x, found) {
+					found = append(found, x)
+				}
+				counts[i][x]++
+			}
+		}
+	}
+	values := v.Values
+	if len(values) == 0 {
+		sort.Strings(found)
+		values = found
+	}
+
+	var lines []Line
+	for _, x := range values {
+		out := make([]string, len(byCol))
+		for i := range byCol {
+			out[i] = countPct(counts[i][x], t.N[i], v.Dec)
+		}
+		text := x
+		if f, ok := v.Format[x]; ok {
+			text = f
+		}
+		lines = append(lines, Line{Stat: text, Values: out})
+	}
+	var p string
+	if t.Tests {
+		var table [][]int
+		for _, x := range values {
+			table = append(table, []int{counts[0][x], counts[1][x]})
+		}
+		p = fmtTest(CPStats.RunCat(CPStats.CatTest(spec.Test, table), table))
+	}
+	return lines, p
+}
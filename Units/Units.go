@@ -0,0 +1,133 @@
+// Units of measurement and their conversion.
+//
+// Each test has a standard unit, in which standardized results (e.g. VSSTRESN)
+// are reported. Sites may record results in their local units, which depend on
+// the country of the site. Conversions are defined by a table of linear
+// relationships to = from * FACTOR + OFFSET; the reverse direction is derived
+// from the same entry.
+//
+// Conversion table:
+// - kPa -> mmHg  x 7.50062
+// - lb  -> kg    x 0.45359237
+// - in  -> cm    x 2.54
+// - F   -> C     (x - 32) * 5/9
+package Units
+
+import (
+	"fmt"
+	"math"
+)
+
+// A linear conversion between two units
+type conversion struct {
+	from   string
+	to     string
+	factor float64
+	offset float64
+}
+
+// The conversion table
+var conversions = []conversion{
+	{"kPa", "mmHg", 7.50062, 0},
+	{"lb", "kg", 0.45359237, 0},
+	{"in", "cm", 2.54, 0},
+	{"F", "C", 5.0 / 9.0, -32 * 5.0 / 9.0},
+}
+
+// Standard units by test code
+var standard = map[string]string{
+	"SBP":    "mmHg",
+	"DBP":    "mmHg",
+	"HR":     "bpm",
+	"HEIGHT": "cm",
+	"WEIGHT": "kg",
+	"BMI":    "kg/m2",
+	"TEMP":   "C",
+	"RESP":   "breaths/min",
+}
+
+// Local units by country, where they differ from the standard ones
+var local = map[string]map[string]string{
+	"USA": {"HEIGHT": "in", "WEIGHT": "lb", "TEMP": "F"},
+	"SWE": {"SBP": "kPa", "DBP": "kPa"},
+}
+
+// Determine whether a unit is known, i.e. is a standard unit or appears
+// in the conversion table
+func Known(unit string) bool {
+	for _, u := range standard {
+		if u == unit {
+			return true
+		}
+	}
+	for _, c := range conversions {
+		if c.from == unit || c.to == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// Return an error if the unit is not known
+func Validate(unit string) error {
+	if !Known(unit) {
+		return fmt.Errorf("unknown unit %q", unit)
+	}
+	return nil
+}
+
+// The standard unit of a test. Blank for an unknown test.
+func Standard(testcd string) string {
+	return standard[testcd]
+}
+
+// The unit a test is recorded in for a country.
+func Local(country string, testcd string) string {
+	if u, ok := local[country][testcd]; ok {
+		return u
+	}
+	return standard[testcd]
+}
+
+// Convert a value from one unit to another.
+// An error is returned if either unit is unknown or there is no conversion between them.
+func Convert(value float64, from string, to string) (float64, error) {
+	if err := Validate(from); err != nil {
+		return 0, err
+	}
+	if err := Validate(to); err != nil {
+		return 0, err
+	}
+	if from == to {
+		return value, nil
+	}
+	for _, c := range conversions {
+		if c.from == from && c.to == to {
+			return value*c.factor + c.offset, nil
+		}
+		if c.from == to && c.to == from {
+			return (value - c.offset) / c.factor, nil
+		}
+	}
+	return 0, fmt.Errorf("no conversion from %s to %s", from, to)
+}
+
+// Convert a result of a test in the given unit to the test's standard unit.
+// An error is returned for an unknown test or a unit that cannot be converted.
+func Standardize(testcd string, value float64, unit string) (float64, string, error) {
+	std := Standard(testcd)
+	if std == "" {
+		return 0, "", fmt.Errorf("no standard unit for test %q", testcd)
+	}
+	v, err := Convert(value, unit, std)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %v", testcd, err)
+	}
+	return v, std, nil
+}
+
+// Round a value to a number of decimal places
+func Round(value float64, dec int) float64 {
+	p := math.Pow(10, float64(dec))
+	return math.Round(value*p) / p
+}
@@ -8,9 +8,9 @@
 // - SITEID  	Char 4  Site Identifier
 // - VSSEQ   	Num	 	Sequence number (Key variable 2)
 // - VISITNUM	Num     Visit number (0=Screening, 1-14=Dosing visits and assessments)
-// - VSTESTCD	Char 3  Test code
+// - VSTESTCD	Char 6  Test code
 // - VSTEST		Char 30 Test description
-// - VSORRES	Num 	Original recorded result
+// - VSORRES	Num 	Original recorded result, in the local units of the site's country
 // - VSORRESU   Char    Units of original result
 // - VSSTRESC   Char	Standardized result in char form
 // - VSSTRESN   Num     Standardized result in numeric form
@@ -18,6 +18,24 @@
 // - VSBLFL     Char    Flags baseline visit
 // - VSDTC      Date    Date of visit in ISO8601
 // - VSDY    	Num     Study Day of collection
+// - VSPOS      Char    Position of subject during measurement (SITTING, STANDING, SUPINE)
+// - VSTPT      Char    Planned time point name e.g. READING 2
+// - VSTPTNUM   Num     Planned time point number i.e. the reading within position and visit
+//
+// Tests:
+// - SBP, DBP, HR  Blood pressures and heart rate. Taken in triplicate sitting at
+//                 each visit; at screening single supine and standing readings are also taken.
+// - HEIGHT        Height (cm), at screening only.
+// - WEIGHT        Weight (kg), single reading at each visit.
+// - BMI           Body Mass Index (kg/m2), derived at each visit from WEIGHT and
+//                 the screening HEIGHT: WEIGHT / (HEIGHT/100)^2.
+// - TEMP          Body temperature (C), single reading at each visit.
+// - RESP          Respiratory rate (breaths/min), single reading at each visit.
+// Baselines of HEIGHT, WEIGHT, TEMP and RESP depend on the subject's sex and age in DM.
+// Normal ranges in standard units are given for all tests but HEIGHT and WEIGHT
+// (see NormalRange); results are classified against them by RangeInd.
+// Results are recorded in the local units of the subject's COUNTRY (e.g. lb and
+// in for the USA) and standardized via the conversion table in package Units.
 
 package VS
 
@@ -25,6 +43,7 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"os"
 	"sort"
@@ -33,6 +52,9 @@ import (
 	"time"
 
 	"github.com/phil0lucas/GoForCP/CPUtils"
+	"github.com/phil0lucas/GoForCP/DM"
+	"github.com/phil0lucas/GoForCP/Missing"
+	"github.com/phil0lucas/GoForCP/Units"
 )
 
 // This will mirror the metadata above with more natural types.
@@ -56,6 +78,9 @@ type Vsrec struct {
 	Vsblfl   bool
 	Vsdtc    time.Time
 	Vsdy     int
+	Vspos    string
+	Vstpt    string
+	Vstptnum int
 }
 
 //	The type vsrecs models a 'data set' as a slice of
@@ -63,21 +88,212 @@ type Vsrec struct {
 type vsrecs []*Vsrec
 
 // The program will be run with flags to specify the input & output files
-var testcodes = []string{"SBP", "DBP", "HR"}
-var testnames = []string{"Systolic Blood Pressure", "Diastolic Blood Pressure", "Heart Rate"}
+var testcodes = []string{"SBP", "DBP", "HR", "HEIGHT", "WEIGHT", "BMI", "TEMP", "RESP"}
+var testnames = []string{"Systolic Blood Pressure", "Diastolic Blood Pressure", "Heart Rate",
+	"Height", "Weight", "Body Mass Index", "Temperature", "Respiratory Rate"}
+
+// Tests taken in triplicate in several positions. The others are single readings.
+var repeated = []string{"SBP", "DBP", "HR"}
 
 const (
 	domain = "VS"
+	nReps  = 3 // Number of sitting readings per visit
+)
+
+// Positions in the order they are taken at a visit
+var positions = []string{"SUPINE", "STANDING", "SITTING"}
+
+// Normal ranges of the tests in standard units, low and high limits
+var normalRanges = map[string][2]float64{
+	"SBP":  {90, 140},
+	"DBP":  {60, 90},
+	"HR":   {60, 100},
+	"BMI":  {18.5, 25},
+	"TEMP": {36, 37.5},
+	"RESP": {12, 20},
+}
+
+// The normal range of a test in standard units; false if it has none
+func NormalRange(tcode string) (float64, float64, bool) {
+	r, ok := normalRanges[tcode]
+	return r[0], r[1], ok
+}
+
+// Classify a standardized result against the normal range of its test as
+// LOW, NORMAL or HIGH, the limits being normal.
+// Blank if the result is missing or the test has no range.
+func RangeInd(tcode string, result *float64) string {
+	lo, hi, ok := NormalRange(tcode)
+	switch {
+	case !ok || result == nil:
+		return ""
+	case *result < lo:
+		return "LOW"
+	case *result > hi:
+		return "HIGH"
+	}
+	return "NORMAL"
+}
+
+// The direction in which a result of a test is worse, for the worst
+// post-baseline value: the higher, the lower, or either way, a result
+// further outside the normal range being worse and a HIGH result worse than
+// a LOW one, as in the shift tables
+const (
+	WorseHigh = 1
+	WorseLow  = -1
+	WorseBoth = 0
 )
 
+// The direction of each test; WorseHigh for a test not given
+var worseDirections = map[string]int{
+	"SBP":    WorseBoth,
+	"DBP":    WorseBoth,
+	"HR":     WorseBoth,
+	"HEIGHT": WorseHigh,
+	"WEIGHT": WorseHigh,
+	"BMI":    WorseBoth,
+	"TEMP":   WorseBoth,
+	"RESP":   WorseBoth,
+}
+
+// The direction in which a result of a test is worse
+func WorseDirection(tcode string) int {
+	if d, ok := worseDirections[tcode]; ok {
+		return d
+	}
+	return WorseHigh
+}
+
+// Whether standardized result a of a test is worse than result b. Either
+// way, results in the normal range are worse the further they are from
+// its middle; a test with no range is worse the higher.
+func Worse(tcode string, a float64, b float64) bool {
+	lo, hi, ok := NormalRange(tcode)
+	switch d := WorseDirection(tcode); {
+	case d == WorseLow:
+		return a < b
+	case d == WorseHigh || !ok:
+		return a > b
+	}
+	rank := func(x float64) (int, float64) {
+		switch {
+		case x > hi:
+			return 2, x - hi
+		case x < lo:
+			return 1, lo - x
+		}
+		return 0, math.Abs(x - (lo+hi)/2)
+	}
+	ra, da := rank(a)
+	rb, db := rank(b)
+	if ra != rb {
+		return ra > rb
+	}
+	return da > db
+}
+
+// A single planned reading at a visit
+type reading struct {
+	pos    string
+	tptnum int
+}
+
+// The schedule of readings of a test for a visit.
+// For the repeated tests all visits have the sitting triplicate; screening
+// also has one supine and one standing reading.
+// HEIGHT is only taken at screening; the remaining tests have a single
+// reading with no position at each visit.
+func readings(tcode string, visit int) []reading {
+	var r []reading
+	if tcode == "HEIGHT" && visit > 0 {
+		return r
+	}
+	if !CPUtils.StringInSlice(tcode, repeated) {
+		return append(r, reading{"", 0})
+	}
+	if visit == 0 {
+		r = append(r, reading{"SUPINE", 1}, reading{"STANDING", 1})
+	}
+	for t := 1; t <= nReps; t++ {
+		r = append(r, reading{"SITTING", t})
+	}
+	return r
+}
+
+// Systematic difference of a reading from the sitting value by position
+func posShift(tcode string, pos string) float64 {
+	switch pos {
+	case "STANDING":
+		switch tcode {
+		case "SBP":
+			return -5
+		case "DBP":
+			return 2
+		case "HR":
+			return 8
+		}
+	case "SUPINE":
+		switch tcode {
+		case "SBP":
+			return 3
+		case "DBP":
+			return -2
+		case "HR":
+			return -4
+		}
+	}
+	return 0
+}
+
+// Generate one reading from the underlying value at the visit,
+// allowing for position and measurement variability between readings.
+func getReading(value *float64, tcode string, pos string) *float64 {
+	if value == nil {
+		return nil
+	}
+	v := *value + posShift(tcode, pos) + randValue(3, -2)
+	return &v
+}
+
+// Order of positions when sorting
+func posOrder(pos string) int {
+	for i, p := range positions {
+		if p == pos {
+			return i
+		}
+	}
+	return len(positions)
+}
+
 // Return a random integer in the specified range
 func randValue(max, min int) float64 {
 	rand.Seed(time.Now().UTC().UnixNano())
 	return float64(rand.Intn(max-min) + min)
 }
 
-// Generate random baseline values for each test performed
-func genBaseline(tcode string) float64 {
+// Return a random value from a normal distribution rounded to 1 decimal place
+func randNorm(mean, sd float64) float64 {
+	rand.Seed(time.Now().UTC().UnixNano())
+	v := rand.NormFloat64()*sd + mean
+	return float64(int64(v*10+0.5)) / 10
+}
+
+// Generate random baseline values for each test performed.
+// Height, weight, temperature and respiratory rate depend on sex and age;
+// when these are missing the midpoint of the sexes and an age of 50 are used.
+func genBaseline(tcode string, age *int, sex *string) float64 {
+	a := 50.0
+	if age != nil {
+		a = float64(*age)
+	}
+	var male float64 = 0.5
+	if sex != nil && *sex == "M" {
+		male = 1
+	} else if sex != nil && *sex == "F" {
+		male = 0
+	}
+
 	switch tcode {
 	// return rand.Intn(max - min) + min
 	case "HR":
@@ -86,6 +302,29 @@ func genBaseline(tcode string) float64 {
 		return randValue(160, 120)
 	case "DBP":
 		return randValue(120, 90)
+	case "HEIGHT":
+		// Men about 13cm taller. Loss of height from 50 years
+		h := 163 + 13*male
+		if a > 50 {
+			h -= (a - 50) * 0.1
+		}
+		return randNorm(h, 7)
+	case "WEIGHT":
+		// Weight rising gently with age
+		return randNorm(70+14*male+(a-20)*0.15, 12)
+	case "TEMP":
+		// Slightly lower in the elderly
+		t := 36.7
+		if a >= 65 {
+			t -= 0.2
+		}
+		return randNorm(t, 0.3)
+	case "RESP":
+		r := 14 + 1*(1-male)
+		if a >= 60 {
+			r += 2
+		}
+		return randNorm(r, 2)
 	}
 	return 0.0
 }
@@ -123,15 +362,102 @@ func getOrigRes(baseline float64, visitnum int, armcd *int) *float64 {
 	}
 }
 
-// Blood pressures in mm of Mercury, heart rate in beats per minute
-func getUnits(testcode string) (string, string) {
-	if testcode == "HR" {
-		return "bpm", "bpm"
-	} else if testcode == "SBP" || testcode == "DBP" {
-		return "mmHg", "mmHg"
-	} else {
-		return "", ""
+// Generate random results for the tests with no treatment effect.
+// The value varies about the baseline from visit to visit.
+func getOtherRes(baseline float64, visitnum int, armcd *int, tcode string) *float64 {
+	if armcd == nil {
+		return nil
+	}
+	if visitnum == 0 {
+		return &baseline
+	}
+	var v float64
+	switch tcode {
+	case "WEIGHT":
+		v = randNorm(baseline, 1)
+	case "TEMP":
+		v = randNorm(baseline, 0.2)
+	case "RESP":
+		v = randNorm(baseline, 1.5)
+	default:
+		v = baseline
 	}
+	return &v
+}
+
+// Derive BMI in kg/m2 from weight in kg and height in cm.
+// Missing if either is missing.
+func deriveBMI(weight, height *float64) *float64 {
+	if weight == nil || height == nil || *height == 0 {
+		return nil
+	}
+	m := *height / 100
+	v := *weight / (m * m)
+	v = float64(int64(v*10+0.5)) / 10
+	return &v
+}
+
+// Record a result generated in standard units in the units used at the
+// site's country and derive the standardized result from the recorded value,
+// as would happen with collected data. Standard units are blood pressures in
+// mm of Mercury, heart rate in beats per minute, height in cm, weight in kg and
+// temperature in degrees Celsius (see package Units).
+// Unknown units or a missing conversion cause a panic: the tables are wrong.
+func recordUnits(result *float64, tcode string, country string) (*float64, string, *float64, string) {
+	if tcode == "" {
+		return nil, "", nil, ""
+	}
+	stresu := Units.Standard(tcode)
+	orresu := Units.Local(country, tcode)
+	if result == nil {
+		return nil, orresu, nil, stresu
+	}
+	orres, err := Units.Convert(*result, stresu, orresu)
+	if err != nil {
+		panic(fmt.Sprintf("%s: %v", tcode, err))
+	}
+	orres = Units.Round(orres, 1)
+	stresn, _, err := Units.Standardize(tcode, orres, orresu)
+	if err != nil {
+		panic(err)
+	}
+	stresn = Units.Round(stresn, 2)
+	return &orres, orresu, &stresn, stresu
+}
+
+// Check the units of VS records, returning an error for each record with an
+// unknown original unit, a standardized unit other than the standard unit
+// of the test, or an original result that does not convert to the
+// standardized one.
+func CheckUnits(vs []*Vsrec) []error {
+	var errs []error
+	for _, v := range vs {
+		if v.Vstestcd == "" {
+			continue
+		}
+		orresu := CPUtils.StrP2Str(v.Vsorresu)
+		stresu := CPUtils.StrP2Str(v.Vsstresu)
+		id := v.Usubjid + " VSSEQ " + strconv.Itoa(v.Vsseq)
+		if err := Units.Validate(orresu); err != nil {
+			errs = append(errs, fmt.Errorf("%s: VSORRESU %v", id, err))
+			continue
+		}
+		if stresu != Units.Standard(v.Vstestcd) {
+			errs = append(errs, fmt.Errorf("%s: VSSTRESU %q is not the standard unit %q of %s",
+				id, stresu, Units.Standard(v.Vstestcd), v.Vstestcd))
+			continue
+		}
+		if v.Vsorres != nil && v.Vsstresn != nil {
+			std, _, err := Units.Standardize(v.Vstestcd, *v.Vsorres, orresu)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", id, err))
+			} else if math.Abs(std-*v.Vsstresn) > 0.01 {
+				errs = append(errs, fmt.Errorf("%s: VSORRES %.2f %s does not convert to VSSTRESN %.2f %s",
+					id, *v.Vsorres, orresu, *v.Vsstresn, stresu))
+			}
+		}
+	}
+	return errs
 }
 
 // Len, Swap and Less are required for the Sort Interface. An interface
@@ -161,7 +487,13 @@ func (t vsrecs) Less(i, j int) bool {
 	if t[i].Vstestcd > t[j].Vstestcd {
 		return false
 	}
-	return t[i].Visitnum < t[j].Visitnum
+	if t[i].Visitnum != t[j].Visitnum {
+		return t[i].Visitnum < t[j].Visitnum
+	}
+	if posOrder(t[i].Vspos) != posOrder(t[j].Vspos) {
+		return posOrder(t[i].Vspos) < posOrder(t[j].Vspos)
+	}
+	return t[i].Vstptnum < t[j].Vstptnum
 }
 
 // Allocates test codes and their description
@@ -182,8 +514,38 @@ func flagBline(visit int) bool {
 	}
 }
 
-// Writes the generated data to a CSV correctky sorted by Usubjid-Vstestcd-Visitnum
-func WriteVS(infile, outfile *string) {
+// To allow the arm to be used as a covariate for missing data
+var arm = map[int]string{0: "Placebo", 1: "Active"}
+
+// Apply the missing-data rules for the test to a generated result.
+// The covariates available to MAR rules are SITEID, ARM, AGE, SEX, COUNTRY,
+// VISITNUM, VSPOS, VSTPTNUM and BASELINE (the subject's screening value for
+// the test); MNAR rules compare
+// against the result itself.
+func applyMiss(m *Missing.Engine, vstestcd string, cov map[string]string, res *float64) *float64 {
+	if res == nil {
+		return nil
+	}
+	if m.Flag(domain, vstestcd, cov, CPUtils.FloatP2Str(res, 1)) {
+		return nil
+	}
+	return res
+}
+
+// Writes the generated data to a CSV correctky sorted by
+// Usubjid-Vstestcd-Visitnum-Vspos-Vstptnum.
+// The DM file provides the age and sex of each subject for the baselines.
+// Results are set missing according to the rules in the missing-data
+// specification file (see package Missing); by default none are missing.
+func WriteVS(infile, dmfile, outfile, missfile *string) {
+	miss := Missing.ReadSpec(missfile)
+
+	// Subject demographics keyed by Usubjid
+	demog := make(map[string]*DM.Dmrec)
+	for _, d := range DM.ReadDM(dmfile) {
+		demog[d.Usubjid] = d
+	}
+
 	// open the file and pass it to a Scanner object
 	file, err := os.Open(*infile)
 	if err != nil {
@@ -221,20 +583,46 @@ func WriteVS(infile, outfile *string) {
 		// 		fmt.Printf("%v %v %v \n", dmdtc, endv, endvn)
 		// 		CPUtils.PrintIntP(armcd)
 
+		// Age, sex and country of the subject, missing if not in DM
+		var age *int
+		var sex *string
+		var country string
+		d := demog[usubjid]
+		if d != nil {
+			age = d.Age
+			sex = d.Sex
+			country = d.Country
+		}
+
 		// Add in the visits up to the generated end-visit
 		// Subjects with just visit 0 are screening failures.
 		// Subjects with a final visit number < 14 are withdrawers.
 
+		// Height and weights by visit, retained for the derivation of BMI
+		var height *float64
+		weights := make(map[int]*float64)
+
 		// Test codes
 		for j := 0; j < len(testcodes); j++ {
 			vstestcd, vstest := tcodes(testcodes, testnames, j, rectype)
 			// 			fmt.Printf("Testcode=%s Test=%s\n", vstestcd, vstest)
 
-			baseline := genBaseline(testcodes[j])
+			baseline := genBaseline(testcodes[j], age, sex)
 			// 			fmt.Printf("Test code %s value %v\n", testcodes[j], baseline)
 
-			vsorresu, vsstresu := getUnits(vstestcd)
-			// 			fmt.Printf("   Test code units %s, %s\n", vsorresu, vsstresu)
+			// Covariates for the missing-data rules
+			cov := map[string]string{
+				"SITEID":   siteid,
+				"BASELINE": strconv.FormatFloat(baseline, 'f', 1, 64),
+				"AGE":      CPUtils.IntP2Str(age),
+				"SEX":      CPUtils.StrP2Str(sex),
+			}
+			if d != nil {
+				cov["COUNTRY"] = country
+			}
+			if armcd != nil {
+				cov["ARM"] = arm[*armcd]
+			}
 
 			// Visits
 			for k := 0; k <= endvn; k++ {
@@ -242,30 +630,73 @@ func WriteVS(infile, outfile *string) {
 				// 				fmt.Println(vsblfl)
 				// Recall ARMCD is now a pointer to an int.
 				// VSORRES is a pointer to a float64, nil being a missing value
-				vsorres := getOrigRes(baseline, k, armcd)
-				// 				CPUtils.PrintFloatP(vsorres)
+				cov["VISITNUM"] = strconv.Itoa(k)
+				var value *float64
+				if CPUtils.StringInSlice(testcodes[j], repeated) {
+					value = getOrigRes(baseline, k, armcd)
+				} else {
+					value = getOtherRes(baseline, k, armcd, testcodes[j])
+				}
 				vsdtc := dmdtc.AddDate(0, 0, (k * 14))
 				vsdy := k * 14
 
-				vs = append(vs, &Vsrec{
-					Studyid:  studyid,
-					Domain:   domain,
-					Usubjid:  usubjid,
-					Subjid:   subjid,
-					Siteid:   siteid,
-					Visitnum: k,
-					Vstestcd: vstestcd,
-					Vstest:   vstest,
-					Vsorres:  vsorres,
-					Vsstresn: vsorres,
-					Vsstresc: CPUtils.FloatP2StrP(vsorres, 2),
-					Vsorresu: &vsorresu,
-					Vsstresu: &vsstresu,
-					Vsblfl:   vsblfl,
-					Vsdtc:    vsdtc,
-					Vsdy:     vsdy,
-				})
-
+				// Screening failures have no tests and so a single empty record
+				sched := readings(testcodes[j], k)
+				if vstestcd == "" {
+					sched = []reading{{"", 0}}
+				}
+
+				// Readings within the visit
+				for _, r := range sched {
+					cov["VSPOS"] = r.pos
+					cov["VSTPTNUM"] = strconv.Itoa(r.tptnum)
+					// The result in standard units
+					var result *float64
+					switch {
+					case vstestcd == "BMI":
+						// Derived, so only missing when its components are
+						result = deriveBMI(weights[k], height)
+					case CPUtils.StringInSlice(vstestcd, repeated):
+						result = applyMiss(miss, vstestcd, cov, getReading(value, vstestcd, r.pos))
+					default:
+						result = applyMiss(miss, vstestcd, cov, value)
+					}
+
+					// As recorded at the site and standardized
+					vsorres, vsorresu, vsstresn, vsstresu := recordUnits(result, vstestcd, country)
+					if vstestcd == "HEIGHT" {
+						height = vsstresn
+					} else if vstestcd == "WEIGHT" {
+						weights[k] = vsstresn
+					}
+					// 					CPUtils.PrintFloatP(vsorres)
+					var vstpt string
+					if r.tptnum > 0 {
+						vstpt = "READING " + strconv.Itoa(r.tptnum)
+					}
+
+					vs = append(vs, &Vsrec{
+						Studyid:  studyid,
+						Domain:   domain,
+						Usubjid:  usubjid,
+						Subjid:   subjid,
+						Siteid:   siteid,
+						Visitnum: k,
+						Vstestcd: vstestcd,
+						Vstest:   vstest,
+						Vsorres:  vsorres,
+						Vsstresn: vsstresn,
+						Vsstresc: CPUtils.FloatP2StrP(vsstresn, 2),
+						Vsorresu: &vsorresu,
+						Vsstresu: &vsstresu,
+						Vsblfl:   vsblfl,
+						Vsdtc:    vsdtc,
+						Vsdy:     vsdy,
+						Vspos:    r.pos,
+						Vstpt:    vstpt,
+						Vstptnum: r.tptnum,
+					})
+				} // End r loop
 			} // End k loop
 		} //	End j loop
 	} // End i loop
@@ -286,6 +717,19 @@ func WriteVS(infile, outfile *string) {
 		vs[ii].Vsseq = count
 	}
 
+	// Validate the units before writing
+	if errs := CheckUnits(vs); len(errs) > 0 {
+		for _, e := range errs {
+			log.Println(e)
+		}
+		log.Fatalf("%d records with invalid units", len(errs))
+	}
+
+	WriteCSV(vs, outfile)
+}
+
+// Write a slice of VS records to a CSV file in the layout read by ReadVS.
+func WriteCSV(vs []*Vsrec, outfile *string) {
 	// Write to external file.
 	fo, err := os.Create(*outfile)
 	if err != nil {
@@ -308,13 +752,16 @@ func WriteVS(infile, outfile *string) {
 				vs[ii].Vstestcd + "," +
 				vs[ii].Vstest + "," +
 				CPUtils.FloatP2Str(vs[ii].Vsorres, 1) + "," +
-				CPUtils.FloatP2Str(vs[ii].Vsstresn, 1) + "," +
+				CPUtils.FloatP2Str(vs[ii].Vsstresn, 2) + "," +
 				CPUtils.StrP2Str(vs[ii].Vsstresc) + "," +
 				CPUtils.StrP2Str(vs[ii].Vsorresu) + "," +
 				CPUtils.StrP2Str(vs[ii].Vsstresu) + "," +
 				strconv.FormatBool(vs[ii].Vsblfl) + "," +
 				vs[ii].Vsdtc.Format("2006-01-02") + "," +
-				strconv.Itoa(vs[ii].Vsdy) +
+				strconv.Itoa(vs[ii].Vsdy) + "," +
+				vs[ii].Vspos + "," +
+				vs[ii].Vstpt + "," +
+				strconv.Itoa(vs[ii].Vstptnum) +
 				"\n")
 
 		if err != nil {
@@ -364,6 +811,15 @@ func ReadVS(infile *string) []*Vsrec {
 		vsdtc, _ := time.Parse("2006-01-02", strings.Split(str, ",")[15])
 		vsdy, _ := strconv.Atoi(strings.Split(str, ",")[16])
 
+		// Position and time point were added later so older files may not have them
+		var vspos, vstpt string
+		var vstptnum int
+		if f := strings.Split(str, ","); len(f) > 19 {
+			vspos = f[17]
+			vstpt = f[18]
+			vstptnum, _ = strconv.Atoi(f[19])
+		}
+
 		vsx = append(vsx, &Vsrec{
 			Studyid:  studyid,
 			Domain:   domain,
@@ -382,7 +838,81 @@ func ReadVS(infile *string) []*Vsrec {
 			Vsblfl:   vsblfl,
 			Vsdtc:    vsdtc,
 			Vsdy:     vsdy,
+			Vspos:    vspos,
+			Vstpt:    vstpt,
+			Vstptnum: vstptnum,
 		})
 	}
 	return vsx
 }
+
+// Compound key for averaging the readings of a visit
+type avgKey struct {
+	Usubjid  string
+	Vstestcd string
+	Visitnum int
+}
+
+// Average the repeated readings taken in a position at each visit, giving one
+// record per Usubjid-Vstestcd-Visitnum as used for analysis.
+// The record carries the values of the first reading, with VSTPT set to
+// AVERAGE, VSTPTNUM to 0 and the results set to the mean of the non-missing
+// readings (missing if all readings are missing).
+// A blank position averages over all positions. Tests with single readings
+// and no position are always included and keep their value.
+func AvgByVisit(vs []*Vsrec, pos string) []*Vsrec {
+	var out []*Vsrec
+	m := make(map[avgKey]*Vsrec)
+	sumOr := make(map[avgKey]float64)
+	sumSt := make(map[avgKey]float64)
+	n := make(map[avgKey]int)
+	for _, v := range vs {
+		if pos != "" && v.Vspos != "" && v.Vspos != pos {
+			continue
+		}
+		k := avgKey{v.Usubjid, v.Vstestcd, v.Visitnum}
+		if _, ok := m[k]; !ok {
+			a := *v
+			a.Vstpt = "AVERAGE"
+			a.Vstptnum = 0
+			a.Vsorres = nil
+			a.Vsstresn = nil
+			a.Vsstresc = nil
+			m[k] = &a
+			out = append(out, &a)
+		}
+		if v.Vsstresn != nil {
+			if v.Vsorres != nil {
+				sumOr[k] += *v.Vsorres
+			}
+			sumSt[k] += *v.Vsstresn
+			n[k]++
+		}
+	}
+	for k, a := range m {
+		if n[k] > 0 {
+			or := sumOr[k] / float64(n[k])
+			st := sumSt[k] / float64(n[k])
+			a.Vsorres = &or
+			a.Vsstresn = &st
+			a.Vsstresc = CPUtils.FloatP2StrP(&st, 2)
+		}
+	}
+	return out
+}
+
+// Results at a visit keyed by Usubjid and then test code, using the average
+// of the sitting readings for the repeated tests.
+func ByVisit(vs []*Vsrec, visitnum int) map[string]map[string]*float64 {
+	m := make(map[string]map[string]*float64)
+	for _, v := range AvgByVisit(vs, "SITTING") {
+		if v.Visitnum != visitnum || v.Vstestcd == "" {
+			continue
+		}
+		if m[v.Usubjid] == nil {
+			m[v.Usubjid] = make(map[string]*float64)
+		}
+		m[v.Usubjid][v.Vstestcd] = v.Vsstresn
+	}
+	return m
+}
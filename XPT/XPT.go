@@ -0,0 +1,217 @@
+// Writer for SAS Version 5 transport (XPORT) files, as required for
+// regulatory submission of data sets.
+//
+// A file holds a single data set (member). All records are 80 bytes:
+// - Library header records with the SAS version and creation date
+// - Member header records with the data set name and label
+// - A NAMESTR header and a 140 byte NAMESTR per variable
+// - An OBS header followed by the observations
+//
+// Numeric values are held as 8 byte IBM mainframe floating point numbers,
+// character values are blank padded to the length of the variable.
+// Dates are numeric, being the number of days since 01JAN1960.
+package XPT
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Definition of a variable.
+// Names are up to 8 characters, labels up to 40.
+// Numeric variables always have a length of 8; Length is used for character variables.
+// A numeric variable with a DATE format expects its values as ISO8601 dates.
+type Var struct {
+	Name    string
+	Label   string
+	Numeric bool
+	Length  int
+	Format  string
+}
+
+// The SAS date origin
+var sasEpoch = time.Date(1960, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Header record prefix and suffix
+const (
+	hdrPrefix = "HEADER RECORD*******"
+	hdrZeros  = "000000000000000000000000000000  "
+)
+
+// Blank pad or truncate a string to a length
+func padStr(s string, n int) string {
+	if len(s) >= n {
+		return s[:n]
+	}
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+// Convert an IEEE double to IBM mainframe floating point.
+// IBM format is a sign bit, a 7 bit base 16 exponent biased by 64
+// and a 56 bit fraction.
+func ieeeToIBM(x float64) []byte {
+	b := make([]byte, 8)
+	if x == 0 {
+		return b
+	}
+	var sign byte
+	if x < 0 {
+		sign = 0x80
+		x = -x
+	}
+	// x = m * 2^p with m in [0.5, 1)
+	m, p := math.Frexp(x)
+	// Express as f * 16^e with f in [1/16, 1)
+	e := int(math.Ceil(float64(p) / 4))
+	frac := uint64(math.Ldexp(m, p-4*e+56) + 0.5)
+	if frac >= 1<<56 {
+		frac >>= 4
+		e++
+	}
+	if e+64 < 0 {
+		return make([]byte, 8)
+	}
+	if e+64 > 127 {
+		panic(fmt.Sprintf("value %g too large for transport file", x))
+	}
+	binary.BigEndian.PutUint64(b, frac)
+	b[0] = sign | byte(e+64)
+	return b
+}
+
+// Format a date as SAS does in headers, e.g. 13APR89:10:20:06
+func sasDateTime(t time.Time) string {
+	return strings.ToUpper(t.Format("02Jan06:15:04:05"))
+}
+
+// Encode a numeric value from its character form. Blank is a missing value.
+// For date variables the value is an ISO8601 date.
+func numValue(v Var, s string) ([]byte, error) {
+	if s == "" {
+		// Standard missing value '.'
+		return []byte{0x2e, 0, 0, 0, 0, 0, 0, 0}, nil
+	}
+	if strings.HasPrefix(v.Format, "DATE") {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid date %q", v.Name, s)
+		}
+		return ieeeToIBM(math.Round(d.Sub(sasEpoch).Hours() / 24)), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid number %q", v.Name, s)
+	}
+	return ieeeToIBM(f), nil
+}
+
+// Write a NAMESTR record for a variable
+func namestr(buf *bytes.Buffer, v Var, varnum int, pos int) {
+	ntype, length := int16(2), int16(v.Length)
+	if v.Numeric {
+		ntype, length = 1, 8
+	}
+	// Split a format such as DATE9. into its name and width
+	fname := strings.TrimRight(v.Format, ".0123456789")
+	fl, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(v.Format, fname), "."))
+
+	binary.Write(buf, binary.BigEndian, ntype)
+	binary.Write(buf, binary.BigEndian, int16(0))
+	binary.Write(buf, binary.BigEndian, length)
+	binary.Write(buf, binary.BigEndian, int16(varnum))
+	buf.WriteString(padStr(strings.ToUpper(v.Name), 8))
+	buf.WriteString(padStr(v.Label, 40))
+	buf.WriteString(padStr(fname, 8))
+	binary.Write(buf, binary.BigEndian, int16(fl))
+	binary.Write(buf, binary.BigEndian, int16(0))
+	binary.Write(buf, binary.BigEndian, int16(0))
+	buf.Write([]byte{0, 0})
+	buf.WriteString(padStr("", 8))
+	binary.Write(buf, binary.BigEndian, int16(0))
+	binary.Write(buf, binary.BigEndian, int16(0))
+	binary.Write(buf, binary.BigEndian, int32(pos))
+	buf.Write(make([]byte, 52))
+}
+
+// Blank pad the buffer to a multiple of 80 bytes
+func pad80(buf *bytes.Buffer) {
+	if r := buf.Len() % 80; r != 0 {
+		buf.WriteString(strings.Repeat(" ", 80-r))
+	}
+}
+
+// Write a data set to a transport file.
+// Each row holds the values of the variables in character form, in the same
+// order as vars; blank numeric values are missing.
+func Write(outfile *string, name string, label string, vars []Var, rows [][]string) error {
+	now := sasDateTime(time.Now())
+	var buf bytes.Buffer
+
+	// Library header
+	buf.WriteString(hdrPrefix + "LIBRARY HEADER RECORD!!!!!!!" + hdrZeros)
+	buf.WriteString(padStr("SAS", 8) + padStr("SAS", 8) + padStr("SASLIB", 8) +
+		padStr("9.1", 8) + padStr("GO", 8) + strings.Repeat(" ", 24) + padStr(now, 16))
+	buf.WriteString(padStr(now, 80))
+
+	// Member header
+	buf.WriteString(hdrPrefix + "MEMBER  HEADER RECORD!!!!!!!000000000000000001600000000140  ")
+	buf.WriteString(hdrPrefix + "DSCRPTR HEADER RECORD!!!!!!!" + hdrZeros)
+	buf.WriteString(padStr("SAS", 8) + padStr(strings.ToUpper(name), 8) + padStr("SASDATA", 8) +
+		padStr("9.1", 8) + padStr("GO", 8) + strings.Repeat(" ", 24) + padStr(now, 16))
+	buf.WriteString(padStr(now, 16) + strings.Repeat(" ", 16) + padStr(label, 40) + padStr("", 8))
+
+	// Variable descriptors
+	buf.WriteString(hdrPrefix + "NAMESTR HEADER RECORD!!!!!!!000000" +
+		fmt.Sprintf("%04d", len(vars)) + "00000000000000000000  ")
+	pos := 0
+	for i, v := range vars {
+		if len(v.Name) > 8 {
+			return fmt.Errorf("variable name %s longer than 8 characters", v.Name)
+		}
+		namestr(&buf, v, i+1, pos)
+		if v.Numeric {
+			pos += 8
+		} else {
+			pos += v.Length
+		}
+	}
+	pad80(&buf)
+
+	// Observations
+	buf.WriteString(hdrPrefix + "OBS     HEADER RECORD!!!!!!!" + hdrZeros)
+	for _, r := range rows {
+		if len(r) != len(vars) {
+			return fmt.Errorf("row has %d values, expected %d", len(r), len(vars))
+		}
+		for i, v := range vars {
+			if v.Numeric {
+				b, err := numValue(v, r[i])
+				if err != nil {
+					return err
+				}
+				buf.Write(b)
+			} else {
+				buf.WriteString(padStr(r[i], v.Length))
+			}
+		}
+	}
+	pad80(&buf)
+
+	fo, err := os.Create(*outfile)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	w := bufio.NewWriter(fo)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
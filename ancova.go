@@ -0,0 +1,224 @@
+// Table of the analysis of covariance of the change from baseline in blood
+// pressure at each post-baseline visit, from ADVS, or of a mixed model for
+// repeated measures (MMRM) over the post-baseline visits.
+// The output is written as PDF by the Report package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/phil0lucas/GoForCP2/ADaM"
+	"github.com/phil0lucas/GoForCP2/CPStats"
+	"github.com/phil0lucas/GoForCP2/Report"
+)
+
+// Input and output files, and the model options
+var infile = flag.String("i", "advs.csv", "Name of ADVS input file")
+var outfile = flag.String("o", "ancova.pdf", "Name of output file")
+var format = flag.String("format", "pdf", "Output format: pdf, rtf, docx, html or txt")
+var site = flag.Bool("s", false, "Include site in the ANCOVA")
+var locf = flag.Bool("l", false, "Impute missing visits by LOCF")
+var mmrm = flag.Bool("r", false, "Fit an MMRM over the visits rather than an ANCOVA at each visit")
+
+// The TFL metadata file and the ID of the output, giving its titles and footnotes
+var metafile = flag.String("m", "tfl.csv", "Name of TFL metadata file")
+var outid = flag.String("id", "T14.2.1", "Output ID in the TFL metadata")
+
+// Parameters analysed, the reference arm and the post-baseline visits
+var params = []string{"SBP", "DBP"}
+var arms = []string{"Placebo", "Active"}
+
+const (
+	firstVisit = 2
+	lastVisit  = 14
+)
+
+// Covariance structures of the MMRM, in order of preference
+var structures = []string{CPStats.UN, CPStats.AR1, CPStats.CS}
+
+// The results of the model for a visit of a parameter, no LS means if the
+// model could not be fitted
+type visitFit struct {
+	avisit string
+	lsmean []CPStats.LSMean
+	diff   []CPStats.LSDiff
+}
+
+// The model results of a parameter, one per visit, and the covariance
+// structure of an MMRM
+type paramFit struct {
+	param     string
+	structure string
+	visits    []visitFit
+}
+
+// The observed records of a parameter in the ITT population, with the
+// LOCF records if asked for
+func selectRecs(advs []*ADaM.Advsrec, paramcd string, locf bool) []*ADaM.Advsrec {
+	recs := ADaM.SelectADVS(advs, paramcd, "", "ITTFL")
+	if locf {
+		recs = append(recs, ADaM.SelectADVS(advs, paramcd, "LOCF", "ITTFL")...)
+	}
+	return recs
+}
+
+// Collect the observations for the model at each visit and fit it
+func analyse(advs []*ADaM.Advsrec, paramcd string, site bool, locf bool) paramFit {
+	recs := selectRecs(advs, paramcd, locf)
+	pf := paramFit{}
+	byVisit := make(map[int][]CPStats.AncovaObs)
+	names := make(map[int]string)
+	for _, a := range recs {
+		pf.param = a.Param
+		if a.Trtp == nil || a.Chg == nil || a.Base == nil {
+			continue
+		}
+		byVisit[a.Avisitn] = append(byVisit[a.Avisitn],
+			CPStats.AncovaObs{Y: *a.Chg, Group: *a.Trtp, Cov: *a.Base, Stratum: a.Siteid})
+		names[a.Avisitn] = a.Avisit
+	}
+	for v := firstVisit; v <= lastVisit; v++ {
+		if len(byVisit[v]) == 0 {
+			continue
+		}
+		fit, err := CPStats.FitAncova(byVisit[v], arms[0], site, 0.05)
+		if err != nil {
+			log.Println(paramcd, names[v], err)
+			pf.visits = append(pf.visits, visitFit{avisit: names[v]})
+			continue
+		}
+		pf.visits = append(pf.visits, visitFit{names[v], fit.LSMean, fit.Diff})
+	}
+	return pf
+}
+
+// Collect the observations of the post-baseline visits and fit an MMRM,
+// with the first covariance structure that converges
+func analyseMMRM(advs []*ADaM.Advsrec, paramcd string, locf bool) paramFit {
+	pf := paramFit{}
+	var obs []CPStats.MMRMObs
+	names := make(map[int]string)
+	for _, a := range selectRecs(advs, paramcd, locf) {
+		pf.param = a.Param
+		if a.Trtp == nil || a.Chg == nil || a.Base == nil || a.Avisitn < firstVisit || a.Avisitn > lastVisit {
+			continue
+		}
+		obs = append(obs, CPStats.MMRMObs{Subject: a.Usubjid, Visit: a.Avisitn,
+			Y: *a.Chg, Base: *a.Base, Group: *a.Trtp})
+		names[a.Avisitn] = a.Avisit
+	}
+	m, err := CPStats.FitMMRM(obs, arms[0], structures, 0.05)
+	if m != nil {
+		for _, e := range m.Attempts {
+			log.Println(paramcd, e)
+		}
+	}
+	if err != nil {
+		log.Println(paramcd, err)
+		for v := firstVisit; v <= lastVisit; v++ {
+			if names[v] != "" {
+				pf.visits = append(pf.visits, visitFit{avisit: names[v]})
+			}
+		}
+		return pf
+	}
+	pf.structure = m.Fit.Structure
+	for _, mv := range m.Visits {
+		pf.visits = append(pf.visits, visitFit{names[mv.Visit], mv.LSMean, mv.Diff})
+	}
+	return pf
+}
+
+// Format a value to a number of decimal places
+func num(v float64, dec int) string {
+	return strconv.FormatFloat(v, 'f', dec, 64)
+}
+
+// The display row of the fit at a visit
+func fitRow(vf visitFit) []string {
+	row := []string{vf.avisit}
+	if vf.lsmean == nil {
+		return append(row, "", "NE", "", "NE", "NE", "")
+	}
+	for _, arm := range arms {
+		var found bool
+		for _, m := range vf.lsmean {
+			if m.Group == arm {
+				row = append(row, strconv.Itoa(m.N), num(m.Est, 2)+" ("+num(m.SE, 2)+")")
+				found = true
+			}
+		}
+		if !found {
+			row = append(row, "0", "")
+		}
+	}
+	if len(vf.diff) == 0 {
+		return append(row, "", "")
+	}
+	d := vf.diff[0]
+	return append(row, num(d.Est, 2)+" ("+num(d.Lower, 2)+", "+num(d.Upper, 2)+")", CPStats.FormatP(d.P))
+}
+
+// The report table of a parameter, the arms spanning their n and LS mean,
+// with the covariance structure of an MMRM in the caption
+func reportTable(pf paramFit) *Report.Table {
+	caption := pf.param
+	if pf.structure != "" {
+		caption += ", " + pf.structure + " covariance"
+	}
+	t := &Report.Table{
+		Caption: caption,
+		Spans: []Report.Span{
+			{Header: "Placebo", From: 1, Span: 2},
+			{Header: "Active", From: 3, Span: 2},
+			{Header: "Active - Placebo", From: 5, Span: 2},
+		},
+		Columns: []Report.Column{
+			{Header: "Visit", Just: "L"},
+			{Header: "n", Just: "R"},
+			{Header: "LS Mean (SE)", Just: "C"},
+			{Header: "n", Just: "R"},
+			{Header: "LS Mean (SE)", Just: "C"},
+			{Header: "Difference (95% CI)", Just: "C"},
+			{Header: "p-value", Just: "C"},
+		},
+	}
+	for _, vf := range pf.visits {
+		t.Rows = append(t.Rows, fitRow(vf))
+	}
+	return t
+}
+
+func main() {
+	flag.Parse()
+
+	// Read ADVS and fit the model for each parameter, at each visit or
+	// over the visits
+	advs := ADaM.ReadADVS(infile)
+	var fits []paramFit
+	for _, p := range params {
+		if *mmrm {
+			fits = append(fits, analyseMMRM(advs, p, *locf))
+		} else {
+			fits = append(fits, analyse(advs, p, *site, *locf))
+		}
+	}
+
+	// 	Report, one page per parameter
+	factors := "as factor"
+	if *site {
+		factors = "and site as factors"
+	}
+	doc := Report.NewFromMeta(Report.ReadMeta(metafile), *outid,
+		map[string]string{"factors": factors})
+	for _, pf := range fits {
+		doc.AddTable(reportTable(pf))
+	}
+	err := doc.Write(outfile, *format)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
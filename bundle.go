@@ -0,0 +1,108 @@
+// Assemble the outputs listed in the TFL metadata file into one PDF, with a
+// table of contents, a bookmark for each output and the pages numbered
+// through the bundle. Each program named in the metadata is built once, then
+// run for each of its outputs with the arguments given in the metadata, the
+// output being saved as a document to be bundled rather than written.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/phil0lucas/GoForCP2/Report"
+)
+
+// The TFL metadata file, the outputs to bundle in order, blank for all, and
+// the bundle file
+var metafile = flag.String("m", "tfl.csv", "Name of TFL metadata file")
+var outids = flag.String("id", "", "Comma separated output IDs, blank for all")
+var outfile = flag.String("o", "bundle.pdf", "Name of output file")
+
+// The name of the binary built from a program, e.g. sum for sum.go
+func binary(prog string) string {
+	return strings.TrimSuffix(prog, ".go")
+}
+
+func main() {
+	flag.Parse()
+
+	meta := Report.ReadMeta(metafile)
+	ids := meta.IDs
+	if *outids != "" {
+		ids = strings.Split(*outids, ",")
+	}
+
+	// 	The saved documents are kept in a temporary directory
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	built := make(map[string]bool)
+	var outputs []Report.Output
+	failed := 0
+	for _, id := range ids {
+		if !meta.Has(id) {
+			fmt.Printf("%s: not in %s\n", id, *metafile)
+			failed++
+			continue
+		}
+		prog := meta.Text(id, Report.MetaProgram, 1, nil)
+		if prog == "" {
+			fmt.Printf("%s: no program\n", id)
+			failed++
+			continue
+		}
+
+		// 	Build the program so that it can name itself in the footer
+		if !built[prog] {
+			out, err := exec.Command("go", "build", "-o", binary(prog), prog).CombinedOutput()
+			if err != nil {
+				fmt.Printf("%s: build of %s failed: %v\n%s", id, prog, err, out)
+				failed++
+				continue
+			}
+			built[prog] = true
+		}
+
+		// 	The output file of the metadata arguments is replaced by the saved document
+		saved := filepath.Join(dir, id+"."+Report.FormatGob)
+		args := append([]string{"-m", *metafile, "-id", id},
+			strings.Fields(meta.Text(id, Report.MetaArgs, 1, nil))...)
+		args = append(args, "-format", Report.FormatGob, "-o", saved)
+		cmd := exec.Command("./"+binary(prog), args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		fmt.Printf("%s: %s %s\n", id, prog, strings.Join(args, " "))
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("%s: %s failed: %v\n", id, prog, err)
+			failed++
+			continue
+		}
+		if _, err := os.Stat(saved); err != nil {
+			fmt.Printf("%s: %s saved no output\n", id, prog)
+			failed++
+			continue
+		}
+		outputs = append(outputs, Report.Output{ID: id, Doc: Report.ReadDoc(&saved)})
+	}
+	os.RemoveAll(dir)
+
+	// 	The contents pages have the headers and footnotes common to all outputs
+	toc := Report.New(meta.Headers("*", nil), meta.Footers("*", nil))
+	toc.Headers.Head5Centre = "Table of Contents"
+	if err := Report.WriteBundle(outfile, toc, outputs); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d outputs failed and are not in %s\n", failed, len(ids), *outfile)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,21 @@
+// This is a driver program to create the ADaM ADSL data set
+
+package main
+
+import (
+	"flag"
+	"github.com/phil0lucas/GoForCP2/ADaM"
+)
+
+// 	The DM and SC input files are given by the -d and -s flags.
+//	ADSL is written as CSV (-o) and as a SAS transport file (-x);
+//	a blank -x suppresses the transport file.
+var dmfile = flag.String("d", "dm.csv", "Name of DM input file")
+var scfile = flag.String("s", "sc.csv", "Name of SC input file")
+var outfile = flag.String("o", "adsl.csv", "Name of output file")
+var xptfile = flag.String("x", "adsl.xpt", "Name of SAS transport output file")
+
+func main() {
+	flag.Parse()
+	ADaM.WriteADSL(dmfile, scfile, outfile, xptfile)
+}
@@ -0,0 +1,20 @@
+// This is a driver program to create the ADaM ADTTE data set
+
+package main
+
+import (
+	"flag"
+	"github.com/phil0lucas/GoForCP2/ADaM"
+)
+
+// 	The ADSL input file is given by the -a flag.
+//	ADTTE is written as CSV (-o) and as a SAS transport file (-x);
+//	a blank -x suppresses the transport file.
+var adslfile = flag.String("a", "adsl.csv", "Name of ADSL input file")
+var outfile = flag.String("o", "adtte.csv", "Name of output file")
+var xptfile = flag.String("x", "adtte.xpt", "Name of SAS transport output file")
+
+func main() {
+	flag.Parse()
+	ADaM.WriteADTTE(adslfile, outfile, xptfile)
+}
@@ -0,0 +1,21 @@
+// This is a driver program to create the ADaM ADVS data set
+
+package main
+
+import (
+	"flag"
+	"github.com/phil0lucas/GoForCP2/ADaM"
+)
+
+// 	The VS and ADSL input files are given by the -v and -a flags.
+//	ADVS is written as CSV (-o) and as a SAS transport file (-x);
+//	a blank -x suppresses the transport file.
+var vsfile = flag.String("v", "vs.csv", "Name of VS input file")
+var adslfile = flag.String("a", "adsl.csv", "Name of ADSL input file")
+var outfile = flag.String("o", "advs.csv", "Name of output file")
+var xptfile = flag.String("x", "advs.xpt", "Name of SAS transport output file")
+
+func main() {
+	flag.Parse()
+	ADaM.WriteADVS(vsfile, adslfile, outfile, xptfile)
+}
@@ -9,11 +9,12 @@ import (
 
 // The program will be run with flags to specify the input & output files.
 // 	When the program is run the input and output files can be changed using the
-//	-i and -o flags
+//	-i and -o flags. The -m flag names an optional missing-data specification.
 var infile = flag.String("i", "sc.csv", "Name of input file")
 var outfile = flag.String("o", "dm.csv", "Name of output file")
+var missfile = flag.String("m", "", "Name of missing-data specification file")
 
 func main() {
 	flag.Parse()
-	DM.WriteDM(infile, outfile)
+	DM.WriteDM(infile, outfile, missfile)
 }
@@ -0,0 +1,45 @@
+// This is a driver program to inject data errors into a generated domain
+// for QC testing. The dirty copy of the domain is written alongside a
+// manifest of the anomalies inserted.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/phil0lucas/GoForCP/DM"
+	"github.com/phil0lucas/GoForCP/VS"
+	"github.com/phil0lucas/GoForCP2/Inject"
+)
+
+// 	The domain (-d), input and output files, manifest, proportion of records
+//	affected per anomaly type and the anomaly types (comma separated, blank
+//	for all) can be changed with flags.
+var domain = flag.String("d", "VS", "Domain to inject errors into (DM or VS)")
+var infile = flag.String("i", "vs.csv", "Name of input file")
+var outfile = flag.String("o", "vs_err.csv", "Name of output file")
+var manfile = flag.String("m", "manifest.csv", "Name of manifest file")
+var rate = flag.Float64("r", 0.01, "Proportion of records affected by each anomaly type")
+var types = flag.String("t", "", "Anomaly types to inject e.g. IMPLAUSIBLE,SWAPPED")
+
+func main() {
+	flag.Parse()
+
+	var t []string
+	if *types != "" {
+		t = strings.Split(strings.ToUpper(*types), ",")
+	}
+	in := Inject.New(*rate, t)
+
+	switch strings.ToUpper(*domain) {
+	case "DM":
+		DM.WriteCSV(in.DM(DM.ReadDM(infile)), outfile)
+	case "VS":
+		VS.WriteCSV(in.VS(VS.ReadVS(infile)), outfile)
+	default:
+		log.Fatalf("Unsupported domain %s", *domain)
+	}
+	in.WriteManifest(manfile)
+}
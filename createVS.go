@@ -9,11 +9,14 @@ import (
 
 // 	The program will be run with flags to specify the input & output files
 // 	When the program is run the input and output files can be changed using the
-//	-i and -o flags
+//	-i and -o flags. The DM file supplying age and sex is given by -d and
+//	the -m flag names an optional missing-data specification.
 var infile = flag.String("i", "sc.csv", "Name of input file")
+var dmfile = flag.String("d", "dm.csv", "Name of DM input file")
 var outfile = flag.String("o", "vs.csv", "Name of output file")
+var missfile = flag.String("m", "", "Name of missing-data specification file")
 
 func main() {
 	flag.Parse()
-	VS.WriteVS(infile, outfile)
+	VS.WriteVS(infile, dmfile, outfile, missfile)
 }
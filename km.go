@@ -0,0 +1,218 @@
+// Kaplan-Meier plot of a time-to-event parameter from ADTTE by treatment arm.
+// The curves are drawn as a PNG file by the gonum plot package, then embedded
+// in a PDF by the Report package with the number of subjects at risk beneath them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/phil0lucas/GoForCP2/ADaM"
+	"github.com/phil0lucas/GoForCP2/Report"
+	"github.com/phil0lucas/GoForCP2/Surv"
+)
+
+var infile = flag.String("i", "adtte.csv", "Name of ADTTE input file")
+var paramcd = flag.String("p", "TTDISC", "Parameter code to plot")
+var outfile = flag.String("o", "km.pdf", "Name of output file")
+var format = flag.String("format", "pdf", "Output format: pdf, rtf, docx, html or txt")
+
+// The TFL metadata file and the ID of the output, giving its titles and footnotes
+var metafile = flag.String("m", "tfl.csv", "Name of TFL metadata file")
+var outid = flag.String("id", "F14.2.2", "Output ID in the TFL metadata")
+
+// The graphics dimensions, leaving space under the plot for the
+// number at risk table.
+var imgX = 207.0 // Image X size in mm
+var imgY = 105.0 // Image Y size in mm
+
+// Times at which the number at risk is shown, every 4 weeks
+var riskTimes = []float64{0, 28, 56, 84, 112, 140, 168}
+
+// Treatment arms in display order
+var arms = []string{"Placebo", "Active"}
+
+// Collect the observations of the ITT population by planned treatment
+func byArm(adtte []*ADaM.Adtterec, paramcd string) (map[string][]Surv.Obs, string) {
+	m := make(map[string][]Surv.Obs)
+	var param string
+	for _, t := range adtte {
+		if t.Paramcd != paramcd || t.Ittfl != "Y" || t.Trtp == nil {
+			continue
+		}
+		param = t.Param
+		m[*t.Trtp] = append(m[*t.Trtp], Surv.Obs{Time: float64(t.Aval), Cnsr: t.Cnsr})
+	}
+	return m, param
+}
+
+// The points of the step function of a KM curve
+func steps(c *Surv.Curve) plotter.XYs {
+	var xys plotter.XYs
+	prev := 1.0
+	for _, p := range c.Points {
+		xys = append(xys, struct{ X, Y float64 }{p.Time, prev})
+		xys = append(xys, struct{ X, Y float64 }{p.Time, p.Surv})
+		prev = p.Surv
+	}
+	return xys
+}
+
+// The points of the censor marks of a KM curve, on the curve at the censoring times
+func censors(c *Surv.Curve) plotter.XYs {
+	var xys plotter.XYs
+	for _, p := range c.Points {
+		if p.NCensor > 0 {
+			xys = append(xys, struct{ X, Y float64 }{p.Time, p.Surv})
+		}
+	}
+	return xys
+}
+
+// Generate a slice of tick mark values to be added to the plot
+func genTicks(min, max, interval float64, dec int) []plot.Tick {
+	var t []plot.Tick
+	for i := min; i <= max+interval/2; i += interval {
+		t = append(t, plot.Tick{Value: i, Label: strconv.FormatFloat(i, 'f', dec, 64)})
+	}
+	return t
+}
+
+// Draw the curves of all arms on a single plot, returning the PNG file name
+func plotKM(curves map[string]*Surv.Curve, maxX float64) string {
+	p, err := plot.New()
+	if err != nil {
+		panic(err)
+	}
+
+	p.X.Label.Text = "Days from First Dose"
+	p.Y.Label.Text = "Probability of Remaining in Study"
+	p.X.Min = 0
+	p.X.Max = maxX
+	p.Y.Min = 0
+	p.Y.Max = 1
+	p.X.Tick.Marker = plot.ConstantTicks(genTicks(0, maxX, 28, 0))
+	p.Y.Tick.Marker = plot.ConstantTicks(genTicks(0, 1, 0.1, 1))
+	p.Legend.Top = false
+	p.Legend.Left = true
+
+	for i, arm := range arms {
+		c, ok := curves[arm]
+		if !ok {
+			continue
+		}
+		l, err := plotter.NewLine(steps(c))
+		if err != nil {
+			panic(err)
+		}
+		l.LineStyle.Color = plotutil.Color(i)
+		l.LineStyle.Width = vg.Points(1)
+
+		s, err := plotter.NewScatter(censors(c))
+		if err != nil {
+			panic(err)
+		}
+		s.GlyphStyle.Color = plotutil.Color(i)
+		s.GlyphStyle.Shape = draw.PlusGlyph{}
+		s.GlyphStyle.Radius = vg.Points(3)
+
+		p.Add(l, s)
+		p.Legend.Add(arm, l)
+	}
+
+	// A temporary output file for the plot, PNG or SVG as the output format needs
+	t_out := "km" + Report.ImageExt(*format)
+	if err := p.Save(vg.Length(imgX)*vg.Millimeter, vg.Length(imgY)*vg.Millimeter, t_out); err != nil {
+		panic(err)
+	}
+	return t_out
+}
+
+// Format a median or its confidence limit. NE when not estimable.
+func fmtMedian(v *float64) string {
+	if v == nil {
+		return "NE"
+	}
+	return strconv.FormatFloat(*v, 'f', 0, 64)
+}
+
+// The figure of the plot with the number at risk and the median
+// survival of each arm beneath it.
+func figure(g string, curves map[string]*Surv.Curve) *Report.Figure {
+	f := &Report.Figure{File: g, Width: imgX, Height: imgY}
+
+	// 	Number at risk table, one row per arm and a column per time
+	labelWidth := 45.0
+	colWidth := (imgX - labelWidth) / float64(len(riskTimes))
+	f.Columns = []Report.Column{{Header: "Number at risk", Width: labelWidth, Just: "L"}}
+	for _, t := range riskTimes {
+		f.Columns = append(f.Columns, Report.Column{Header: "Day " + strconv.FormatFloat(t, 'f', 0, 64), Width: colWidth, Just: "C"})
+	}
+	for _, arm := range arms {
+		c, ok := curves[arm]
+		if !ok {
+			continue
+		}
+		row := []string{"  " + arm}
+		for _, t := range riskTimes {
+			row = append(row, strconv.Itoa(c.AtRisk(t)))
+		}
+		f.Rows = append(f.Rows, row)
+	}
+
+	// 	Median time with its CI and the number of events per arm
+	for _, arm := range arms {
+		c, ok := curves[arm]
+		if !ok {
+			continue
+		}
+		m, l, u := c.Median()
+		f.Text = append(f.Text, fmt.Sprintf("%-8s Events: %d/%d  Median (95%% CI): %s (%s, %s) days",
+			arm, c.Events, c.N, fmtMedian(m), fmtMedian(l), fmtMedian(u)))
+	}
+	return f
+}
+
+func main() {
+	flag.Parse()
+
+	// Read ADTTE and collect the observations of the parameter by arm
+	obs, param := byArm(ADaM.ReadADTTE(infile), *paramcd)
+
+	// Kaplan-Meier estimates per arm, and the longest time observed for the X axis
+	curves := make(map[string]*Surv.Curve)
+	var maxX float64
+	for arm, o := range obs {
+		curves[arm] = Surv.KM(o, 0.05)
+		for _, v := range o {
+			if v.Time > maxX {
+				maxX = v.Time
+			}
+		}
+	}
+	// Extend the axis to the next 4 weeks
+	maxX = float64((int(maxX)/28 + 1) * 28)
+
+	// Log-rank test of the arms
+	chisq, df, p := Surv.LogRank(obs)
+	logrank := fmt.Sprintf("Log-rank Chi-Square=%.2f, DF=%d, p=%.4f.", chisq, df, p)
+
+	// Draw the curves
+	g := plotKM(curves, maxX)
+
+	// 	Report
+	doc := Report.NewFromMeta(Report.ReadMeta(metafile), *outid,
+		map[string]string{"param": param, "logrank": logrank})
+	doc.AddFigure(figure(g, curves))
+	err := doc.Write(outfile, *format)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,43 @@
+// This program creates a listing of any domain from a listing spec file:
+// the variables of the data file, the records selected, the by-groups, the
+// sort order and the columns with their headers, widths and formats (see
+// package Listings). A new domain listing needs only a new spec file.
+// The output is written by the Report package, as PDF by default.
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/phil0lucas/GoForCP/Report"
+	"github.com/phil0lucas/GoForCP2/Listings"
+)
+
+// Input and output files
+var infile = flag.String("i", "vs.csv", "Name of domain input file")
+var specfile = flag.String("s", "vs_listing.csv", "Name of listing spec file")
+var outfile = flag.String("o", "vs_listing.pdf", "Name of output file")
+var format = flag.String("format", "pdf", "Output format: pdf, rtf, docx, html or txt")
+
+// The TFL metadata file and the ID of the output, giving its titles and footnotes
+var metafile = flag.String("m", "tfl.csv", "Name of TFL metadata file")
+var outid = flag.String("id", "L16.2.8.1", "Output ID in the TFL metadata")
+
+func main() {
+	flag.Parse()
+
+	// 	The spec, and the records of the domain read by its variables
+	spec := Listings.ReadSpec(specfile)
+	if len(spec.Vars) == 0 {
+		panic(fmt.Sprintf("%s: no VARS line naming the fields of %s", *specfile, *infile))
+	}
+	rows := Listings.Read(infile, spec.Vars)
+
+	// 	Report, a page group for each by-group
+	doc := Report.NewFromMeta(Report.ReadMeta(metafile), *outid, nil)
+	doc.AddListing(Listings.Build(spec, rows))
+	err := doc.Write(outfile, *format)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -1,5 +1,6 @@
 // The package used here to produce plots can produce 'picture' files.
-// Here, intermediate PNG files have been used and then embedded in a PDF.
+// Here, intermediate PNG files have been used and then embedded in a PDF
+// by the Report package.
 package main
 
 import (
@@ -12,72 +13,24 @@ import (
 	"gonum.org/v1/plot/plotutil"
 	"gonum.org/v1/plot/vg"
 
-	"github.com/jung-kurt/gofpdf"
 	"github.com/montanaflynn/stats"
-	"github.com/phil0lucas/GoForCP2/CPUtils"
-	"github.com/phil0lucas/GoForCP2/SC"
-	"github.com/phil0lucas/GoForCP2/VS"
+	"github.com/phil0lucas/GoForCP2/ADaM"
+	"github.com/phil0lucas/GoForCP2/Report"
 )
 
-var infile1 = flag.String("s", "sc.csv", "Name of SC input file")
-var infile2 = flag.String("v", "vs.csv", "Name of VS input file")
+var infile1 = flag.String("a", "advs.csv", "Name of ADVS input file")
 var outfile = flag.String("o", "plot.pdf", "Name of output file")
+var format = flag.String("format", "pdf", "Output format: pdf, rtf, docx, html or txt")
+
+// The TFL metadata file and the ID of the output, giving its titles and footnotes
+var metafile = flag.String("m", "tfl.csv", "Name of TFL metadata file")
+var outid = flag.String("id", "F14.2.1", "Output ID in the TFL metadata")
 
 // The graphics dimensions in the same ratio as an A4 landscape sheet
 // allowing for title and footnote space.
 var imgX = 207.0 // Image X size in mm
 var imgY = 138.0 // Image Y size in mm
 
-// Header and Footer structs
-type headers struct {
-	head1Left   string
-	head1Right  string
-	head2Left   string
-	head2Right  string
-	head3Left   string
-	head4Centre string
-	head5Centre string
-	head6Centre string
-}
-
-type footers struct {
-	foot1Left   string
-	foot2Left   string
-	foot3Left   string
-	foot4Left   string
-	foot4Centre string
-	foot4Right  string
-}
-
-// Header and Footer text
-// The output variables are pointers to structs holding the text.
-func titles() *headers {
-	h := &headers{
-		head1Left:   "Acme Corp",
-		head1Right:  "CONFIDENTIAL",
-		head2Left:   "XYZ123 / Anti-Hypertensive",
-		head2Right:  "Draft",
-		head3Left:   "Protocol XYZ123",
-		head4Centre: "Study XYZ123",
-		head5Centre: "Blood Pressures by Visit and Treatment Arm",
-		head6Centre: "All Randomized Subjects",
-	}
-	return h
-}
-
-// func footnotes(screened string, failures string) *footers{
-func footnotes() *footers {
-	f := &footers{
-		foot1Left:   "Created with Go 1.8 for linux/amd64.",
-		foot2Left:   "",
-		foot3Left:   "Measurements were taken at 14 day intervals.",
-		foot4Left:   "Page %d of {nb}",
-		foot4Right:  "Run: " + CPUtils.TimeStamp(),
-		foot4Centre: CPUtils.GetCurrentProgram(),
-	}
-	return f
-}
-
 // This provides a structure for summarizing the BPs per Arm (i.e teatment),
 // Test and Visit
 // One object per Arm-Vstestcd-Visitnum
@@ -91,28 +44,21 @@ type perAVV struct {
 // Create a slice of perAVV objects.
 // perAVV objects have a compound key Arm-Vstestcd-Visitnum and Vsstresn values to
 // summarize into plottable points.
-// The Arm (i.e. Treatment Group) is looked up from the input map, simulating the 'merge'
-// 	of the data
-func sMerge(vs []*VS.Vsrec, subjArm map[string]string) []perAVV {
+// The Arm is the actual treatment (TRTA) carried on the ADVS records.
+func sMerge(advs []*ADaM.Advsrec) []perAVV {
 	// 	Output slice of structs
 	var vsp []perAVV
-	for _, v := range vs {
-		var p perAVV
-		p.Arm = subjArm[v.Usubjid]
-		p.Vstestcd = v.Vstestcd
-		p.Visitnum = v.Visitnum
-
-		// Note v.Vsstresn is a pointer; if nil the value is missing.
-		// else p.Vsstresn will take its default zero value i.e. 0
-		if v.Vsstresn != nil {
-			p.Vsstresn = *v.Vsstresn
-		}
-
-		// Include those measures with non-missing Arm, Test and result and,
-		// for this plot, only take the BP measures
-		if p.Arm != "" && p.Vstestcd != "" && p.Vstestcd != "HR" && p.Vsstresn != 0 {
-			vsp = append(vsp, p)
+	for _, v := range advs {
+		// Include those records with non-missing actual treatment and result
+		if v.Trta == nil || v.Aval == nil {
+			continue
 		}
+		vsp = append(vsp, perAVV{
+			Arm:      *v.Trta,
+			Vstestcd: v.Paramcd,
+			Visitnum: v.Avisitn,
+			Vsstresn: *v.Aval,
+		})
 	}
 	return vsp
 }
@@ -243,66 +189,15 @@ func plotBP(pp map[Line]plotter.XYs, group string, n int, minY, maxY float64) st
 		panic(err)
 	}
 
-	// A temporary output PNG file for each plot
-	t_out := "temp" + strconv.Itoa(n) + ".png"
-	// Save the plot to a PNG file.
+	// A temporary output file for each plot, PNG or SVG as the output format needs
+	t_out := "temp" + strconv.Itoa(n) + Report.ImageExt(*format)
+	// Save the plot to the file, its type set by the extension.
 	if err := p.Save(vg.Length(imgX)*vg.Millimeter, vg.Length(imgY)*vg.Millimeter, t_out); err != nil {
 		panic(err)
 	}
 	return t_out
 }
 
-// The overarching PDF that will include the PNG files.
-func WriteReport(outputFile *string, h *headers, f *footers, g1 string, g2 string) error {
-	pdf := gofpdf.New("L", "mm", "A4", "")
-	pdf.SetHeaderFunc(func() {
-		pdf.SetFont("Courier", "", 10)
-		pdf.CellFormat(0, 10, (*h).head1Left, "0", 0, "L", false, 0, "")
-		pdf.CellFormat(0, 10, (*h).head1Right, "0", 0, "R", false, 0, "")
-		pdf.Ln(4)
-		pdf.CellFormat(0, 10, (*h).head2Left, "0", 0, "L", false, 0, "")
-		pdf.CellFormat(0, 10, (*h).head2Right, "0", 0, "R", false, 0, "")
-		pdf.Ln(4)
-		pdf.CellFormat(0, 10, (*h).head3Left, "0", 0, "L", false, 0, "")
-		pdf.Ln(4)
-		pdf.CellFormat(0, 10, (*h).head4Centre, "0", 0, "C", false, 0, "")
-		pdf.Ln(4)
-		pdf.CellFormat(0, 10, (*h).head5Centre, "0", 0, "C", false, 0, "")
-		pdf.Ln(4)
-		pdf.CellFormat(0, 10, (*h).head6Centre, "0", 0, "C", false, 0, "")
-		pdf.Ln(10)
-	})
-
-	pdf.SetFooterFunc(func() {
-		pdf.SetY(-30)
-		pdf.SetFont("Courier", "", 10)
-		pdf.CellFormat(0, 10, (*f).foot1Left, "0", 0, "L", false, 0, "")
-		pdf.Ln(4)
-		pdf.CellFormat(0, 10, (*f).foot2Left, "0", 0, "L", false, 0, "")
-		pdf.Ln(4)
-		pdf.CellFormat(0, 10, (*f).foot3Left, "0", 0, "L", false, 0, "")
-		pdf.Ln(4)
-		pdf.CellFormat(0, 10, fmt.Sprintf((*f).foot4Left, pdf.PageNo()), "", 0, "L", false, 0, "")
-		pdf.SetX(40)
-		pdf.CellFormat(0, 10, (*f).foot4Centre, "", 0, "L", false, 0, "")
-		pdf.CellFormat(0, 10, (*f).foot4Right, "", 0, "R", false, 0, "")
-	})
-	pdf.AliasNbPages("")
-
-	// 	AddPage() executes the generated Header and Footer functions
-	pdf.AddPage()
-	// Include the first graph
-	pdf.Image(g1, 30, 40, imgX, imgY, false, "", 0, "")
-	pdf.AddPage()
-	// Include the second graph
-	pdf.Image(g2, 30, 40, imgX, imgY, false, "", 0, "")
-
-	// 	Output
-	err := pdf.OutputFileAndClose(*outputFile)
-	fmt.Println(err)
-	return err
-}
-
 // Define the extreme values of the blood pressures in order to
 // bound the y axis by the data range.
 func MinMax(vsp []perAVV) (float64, float64) {
@@ -329,25 +224,21 @@ func MinMax(vsp []perAVV) (float64, float64) {
 }
 
 func main() {
-	// Read the 'SC' data and dump into the slice of structs
-	sc := SC.ReadSC(infile1)
-
-	// Create a map of Usubjid as key and Arm as value
-	// Screening failures are eliminated from the lookup map.
-	subjArm := make(map[string]string)
-	for _, v := range sc {
-		if v.Arm != nil {
-			subjArm[v.Usubjid] = *v.Arm
-		}
+	flag.Parse()
+
+	// Read the observed analysis records of the safety population from ADVS.
+	// AVAL is the average of the sitting readings at each visit
+	// so each subject contributes a single value per visit.
+	advs := ADaM.ReadADVS(infile1)
+	var bp []*ADaM.Advsrec
+	for _, p := range []string{"SBP", "DBP"} {
+		bp = append(bp, ADaM.SelectADVS(advs, p, "", "SAFFL")...)
 	}
 
-	// Read the VS data
-	vs := VS.ReadVS(infile2)
-
 	// Create a slice of Point objects.
 	// Point objects have a compound key Arm-Vstestcd-Visitnum and Vsstresn values to
 	// summarize into plottable points
-	vsp := sMerge(vs, subjArm)
+	vsp := sMerge(bp)
 
 	// Determine minimum and maximum BP measures for setting the Y axis
 	minY, maxY := MinMax(vsp)
@@ -367,11 +258,11 @@ func main() {
 	g1 := plotBP(pp, "Placebo", 1, minY, maxY)
 	g2 := plotBP(pp, "Active", 2, minY, maxY)
 
-	// 	Report
-	h := titles()
-	f := footnotes()
-
-	err := WriteReport(outfile, h, f, g1, g2)
+	// 	Report, a page per graph
+	doc := Report.NewFromMeta(Report.ReadMeta(metafile), *outid, nil)
+	doc.AddFigure(&Report.Figure{File: g1, Width: imgX, Height: imgY})
+	doc.AddFigure(&Report.Figure{File: g2, Width: imgX, Height: imgY})
+	err := doc.Write(outfile, *format)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -0,0 +1,76 @@
+// Regenerate the outputs listed in the TFL metadata file in a single run.
+// Each program named in the metadata is built once, then run for each of its
+// outputs with the output ID and the arguments given in the metadata.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/phil0lucas/GoForCP2/Report"
+)
+
+// The TFL metadata file, the outputs to create, blank for all, and their format
+var metafile = flag.String("m", "tfl.csv", "Name of TFL metadata file")
+var outids = flag.String("id", "", "Comma separated output IDs, blank for all")
+var format = flag.String("format", "pdf", "Output format: pdf, rtf, docx, html or txt")
+
+// The name of the binary built from a program, e.g. sum for sum.go
+func binary(prog string) string {
+	return strings.TrimSuffix(prog, ".go")
+}
+
+func main() {
+	flag.Parse()
+
+	meta := Report.ReadMeta(metafile)
+	ids := meta.IDs
+	if *outids != "" {
+		ids = strings.Split(*outids, ",")
+	}
+
+	built := make(map[string]bool)
+	failed := 0
+	for _, id := range ids {
+		if !meta.Has(id) {
+			fmt.Printf("%s: not in %s\n", id, *metafile)
+			failed++
+			continue
+		}
+		prog := meta.Text(id, Report.MetaProgram, 1, nil)
+		if prog == "" {
+			fmt.Printf("%s: no program\n", id)
+			failed++
+			continue
+		}
+
+		// 	Build the program so that it can name itself in the footer
+		if !built[prog] {
+			out, err := exec.Command("go", "build", "-o", binary(prog), prog).CombinedOutput()
+			if err != nil {
+				fmt.Printf("%s: build of %s failed: %v\n%s", id, prog, err, out)
+				failed++
+				continue
+			}
+			built[prog] = true
+		}
+
+		args := append([]string{"-m", *metafile, "-id", id, "-format", *format},
+			strings.Fields(meta.Text(id, Report.MetaArgs, 1, nil))...)
+		cmd := exec.Command("./"+binary(prog), args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		fmt.Printf("%s: %s %s\n", id, prog, strings.Join(args, " "))
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("%s: %s failed: %v\n", id, prog, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d outputs failed\n", failed, len(ids))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,119 @@
+// Shift tables of the normal range category of vital signs from baseline to
+// the worst post-baseline value, by actual treatment, from ADVS.
+// The output is written as PDF by the Report package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/phil0lucas/GoForCP2/ADaM"
+	"github.com/phil0lucas/GoForCP2/Report"
+	"github.com/phil0lucas/GoForCP2/Tables"
+)
+
+// Input and output files and the parameters shown, one per page
+var infile = flag.String("i", "advs.csv", "Name of ADVS input file")
+var outfile = flag.String("o", "shift.pdf", "Name of output file")
+var format = flag.String("format", "pdf", "Output format: pdf, rtf, docx, html or txt")
+var params = flag.String("p", "SBP,DBP,HR", "Comma separated parameter codes")
+
+// The TFL metadata file and the ID of the output, giving its titles and footnotes
+var metafile = flag.String("m", "tfl.csv", "Name of TFL metadata file")
+var outid = flag.String("id", "T14.3.1", "Output ID in the TFL metadata")
+
+// Treatment arms in display order
+var arms = []string{"Placebo", "Active"}
+
+// Normal range categories in display order, and from the least to the most severe
+var categories = []string{"LOW", "NORMAL", "HIGH"}
+var severity = []string{"NORMAL", "LOW", "HIGH"}
+
+// A shift table of a parameter
+type paramShift struct {
+	param string
+	table *Tables.Table
+}
+
+// The shift table of a parameter for the safety population
+func shift(rows []Tables.Row, paramcd string) paramShift {
+	ps := paramShift{param: paramcd}
+	spec := &Tables.ShiftSpec{
+		By:      "TRTA",
+		Columns: arms,
+		Where: func(r Tables.Row) bool {
+			return r.Get("SAFFL") == "Y" && r.Get("PARAMCD") == paramcd
+		},
+		Var: "ANRIND",
+		Base: func(r Tables.Row) bool {
+			return r.Get("ABLFL") == "Y"
+		},
+		Post: func(r Tables.Row) bool {
+			v, _ := strconv.Atoi(r.Get("AVISITN"))
+			return r.Get("ANL01FL") == "Y" && v > 1
+		},
+		Categories: categories,
+		Worst:      severity,
+		Dec:        1,
+	}
+	for _, r := range rows {
+		if r.Get("PARAMCD") == paramcd {
+			ps.param = r.Get("PARAM")
+			break
+		}
+	}
+	ps.table = Tables.Shift(spec, rows)
+	return ps
+}
+
+// The report table of a parameter, the post-baseline categories spanned
+// and a blank line before each arm, its rows kept together
+func reportTable(ps paramShift) *Report.Table {
+	t := ps.table
+	rt := &Report.Table{
+		Caption: ps.param,
+		Spans:   []Report.Span{{Header: "Worst Post-Baseline", From: 2, Span: len(t.Columns) - 1}},
+		Keep:    true,
+		Columns: []Report.Column{
+			{Header: "Treatment", Just: "L"},
+			{Header: "Baseline", Just: "L"},
+		},
+	}
+	for _, c := range t.Columns {
+		rt.Columns = append(rt.Columns, Report.Column{Header: c, Just: "C"})
+	}
+	for i, l := range t.Lines {
+		if i > 0 && l.Label != "" {
+			rt.Rows = append(rt.Rows, nil)
+		}
+		rt.Rows = append(rt.Rows, append([]string{l.Label, l.Stat}, l.Values...))
+	}
+	return rt
+}
+
+func main() {
+	flag.Parse()
+
+	// Read ADVS as rows for the table generator
+	var rows []Tables.Row
+	for _, a := range ADaM.ReadADVS(infile) {
+		rows = append(rows, a)
+	}
+
+	var shifts []paramShift
+	for _, p := range strings.Split(*params, ",") {
+		shifts = append(shifts, shift(rows, p))
+	}
+
+	// 	Report, one page per parameter
+	doc := Report.NewFromMeta(Report.ReadMeta(metafile), *outid, nil)
+	for _, ps := range shifts {
+		doc.AddTable(reportTable(ps))
+	}
+	err := doc.Write(outfile, *format)
+	if err != nil {
+		fmt.Println(err)
+	}
+}